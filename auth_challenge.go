@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// bearerInvalidRequest is the RFC 6750 error code of malformed requests.
+	bearerInvalidRequest = "invalid_request"
+
+	// bearerInvalidToken is the RFC 6750 error code of tokens that are expired, revoked, malformed or invalid.
+	bearerInvalidToken = "invalid_token"
+
+	// bearerInsufficientScope is the RFC 6750 error code of tokens without the privileges the request requires.
+	bearerInsufficientScope = "insufficient_scope"
+)
+
+// bearerErrorCodes maps errors to RFC 6750 error codes. Errors that are not listed carry no error
+// code, such as those of requests without a token, of logins, or of the server.
+var bearerErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrMissingExpField, bearerInvalidRequest},
+	{ErrWrongFormatOfExp, bearerInvalidRequest},
+	{ErrInvalidAuthHeader, bearerInvalidRequest},
+	{ErrExpiredToken, bearerInvalidToken},
+	{ErrInvalidToken, bearerInvalidToken},
+	{ErrInvalidSigningAlgorithm, bearerInvalidToken},
+	{ErrMissingKeyID, bearerInvalidToken},
+	{ErrUnknownKeyID, bearerInvalidToken},
+	{ErrInvalidIssuer, bearerInvalidToken},
+	{ErrInvalidAudience, bearerInvalidToken},
+	{ErrTokenNotValidYet, bearerInvalidToken},
+	{ErrInvalidIssuedAt, bearerInvalidToken},
+	{ErrInvalidTokenType, bearerInvalidToken},
+	{ErrRefreshTokenReused, bearerInvalidToken},
+	{ErrInvalidClaims, bearerInvalidToken},
+	{ErrIdleTimeout, bearerInvalidToken},
+	{ErrInsufficientScope, bearerInsufficientScope},
+	{ErrForbidden, bearerInsufficientScope},
+	{ErrPolicyDenied, bearerInsufficientScope},
+}
+
+// bearerErrorCode returns the RFC 6750 error code of err, or an empty string if it has none.
+// Errors of the jwt library are invalid tokens.
+func bearerErrorCode(err error) string {
+	for _, e := range bearerErrorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) {
+		return bearerInvalidToken
+	}
+	return ""
+}
+
+// challenge returns the WWW-Authenticate header of the response of a request failing with e,
+// or an empty string if the response has no challenge.
+func (mw *GfJWTMiddleware) challenge(e *Error) string {
+	if mw.LegacyChallenge {
+		return "JWT realm=" + mw.Realm
+	}
+
+	switch e.Status {
+	case http.StatusUnauthorized:
+	case http.StatusBadRequest, http.StatusForbidden:
+		if e.BearerCode == "" {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	params := []string{`realm="` + challengeParam(mw.Realm) + `"`}
+	if e.BearerCode != "" {
+		params = append(params,
+			`error="`+e.BearerCode+`"`,
+			`error_description="`+challengeParam(e.Message)+`"`,
+		)
+	}
+
+	var scopeErr *scopeError
+	if errors.As(e.Err, &scopeErr) {
+		params = append(params, `scope="`+challengeParam(strings.Join(scopeErr.scopes, " "))+`"`)
+	}
+
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// challengeParam drops the characters that RFC 6750 does not allow in challenge parameters,
+// which are the quote, the backslash and those out of printable ASCII.
+func challengeParam(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, s)
+}
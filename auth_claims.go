@@ -0,0 +1,175 @@
+package jwt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gogf/gf/v2/util/guid"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TimestampUnit is the unit of the NumericDate claims "exp" and "orig_iat".
+type TimestampUnit int
+
+const (
+	// TimestampMillisecond writes and reads milliseconds since the epoch, as earlier versions did.
+	TimestampMillisecond TimestampUnit = iota
+
+	// TimestampSecond writes and reads seconds since the epoch, as RFC 7519 requires.
+	TimestampSecond
+
+	// TimestampSecondCompat writes seconds, and reads both seconds and milliseconds.
+	TimestampSecondCompat
+)
+
+// maxSecondTimestamp is the largest NumericDate read as seconds, in the year 5138.
+// Larger values are milliseconds.
+const maxSecondTimestamp = 1e11
+
+// timestamp converts t to a NumericDate in the unit of the middleware.
+func (mw *GfJWTMiddleware) timestamp(t time.Time) int64 {
+	if mw.TimestampUnit == TimestampMillisecond {
+		return t.UnixNano() / 1e6
+	}
+	return t.Unix()
+}
+
+// parseTimestamp converts a NumericDate in the unit of the middleware to a time.
+// Milliseconds are rejected in TimestampSecond, so that they are not taken for a distant expiry.
+func (mw *GfJWTMiddleware) parseTimestamp(v interface{}) (time.Time, bool) {
+	n, ok := numericDate(v)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	switch mw.TimestampUnit {
+	case TimestampSecond:
+		if n > maxSecondTimestamp {
+			return time.Time{}, false
+		}
+		return time.Unix(n, 0), true
+	case TimestampSecondCompat:
+		if n > maxSecondTimestamp {
+			return time.Unix(0, n*int64(time.Millisecond)), true
+		}
+		return time.Unix(n, 0), true
+	default:
+		return time.Unix(0, n*int64(time.Millisecond)), true
+	}
+}
+
+// setRegisteredClaims sets the registered claims iss, aud, sub, iat, nbf and jti of a token issued at now.
+func (mw *GfJWTMiddleware) setRegisteredClaims(claims jwt.MapClaims, now time.Time) {
+	if mw.Issuer != "" {
+		claims["iss"] = mw.Issuer
+	}
+
+	switch len(mw.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = mw.Audience[0]
+	default:
+		claims["aud"] = mw.Audience
+	}
+
+	if mw.SubjectFunc != nil {
+		if identity, ok := claims[mw.IdentityKey]; ok {
+			claims["sub"] = mw.SubjectFunc(identity)
+		}
+	}
+
+	claims["iat"] = now.Unix()
+	// tokens reissued for a valid token keep its "nbf": the token they replace is revoked as
+	// they are issued, so they must be usable at once
+	if _, reissued := claims["nbf"]; !reissued {
+		claims["nbf"] = now.Add(mw.NotBefore).Unix()
+	}
+	claims["jti"] = guid.S()
+}
+
+// validateClaims checks the registered claims iss, aud, nbf and iat, the token type of a token, and
+// its typed claims with TypedMiddleware. Claims that are not present in the token are only checked if they are required by the middleware settings.
+func (mw *GfJWTMiddleware) validateClaims(claims MapClaims, tokenType string) error {
+	now := mw.TimeFunc()
+
+	if typ, _ := claims[tokenTypeClaim].(string); typ != tokenType {
+		return ErrInvalidTokenType
+	}
+
+	if mw.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != mw.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+
+	if len(mw.Audience) > 0 && !audienceContains(claims["aud"], mw.Audience) {
+		return ErrInvalidAudience
+	}
+
+	if v, ok := claims["nbf"]; ok {
+		nbf, ok := numericDate(v)
+		if !ok || now.Add(mw.Leeway).Before(time.Unix(nbf, 0)) {
+			return ErrTokenNotValidYet
+		}
+	}
+
+	if v, ok := claims["iat"]; ok {
+		iat, ok := numericDate(v)
+		if !ok || now.Add(mw.Leeway).Before(time.Unix(iat, 0)) {
+			return ErrInvalidIssuedAt
+		}
+	}
+
+	if mw.claimsValidator != nil {
+		return mw.claimsValidator(claims)
+	}
+
+	return nil
+}
+
+// audienceContains reports whether the aud claim, a string or an array of strings,
+// contains any of the accepted audiences.
+func audienceContains(aud interface{}, accepted []string) bool {
+	var audiences []string
+	switch v := aud.(type) {
+	case string:
+		audiences = []string{v}
+	case []string:
+		audiences = v
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	for _, a := range audiences {
+		for _, b := range accepted {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate converts a NumericDate claim value to an integer.
+func numericDate(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			f, err := n.Float64()
+			return int64(f), err == nil
+		}
+		return i, true
+	}
+	return 0, false
+}
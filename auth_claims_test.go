@@ -0,0 +1,231 @@
+package jwt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestTimestampUnit(t *testing.T) {
+	now := time.Unix(1600000000, 123456789)
+
+	gtest.C(t, func(t *gtest.T) {
+		ms := &GfJWTMiddleware{TimestampUnit: TimestampMillisecond}
+		s := &GfJWTMiddleware{TimestampUnit: TimestampSecond}
+		compat := &GfJWTMiddleware{TimestampUnit: TimestampSecondCompat}
+
+		t.Assert(ms.timestamp(now), int64(1600000000123))
+		t.Assert(s.timestamp(now), int64(1600000000))
+		t.Assert(compat.timestamp(now), int64(1600000000))
+
+		parsed, ok := ms.parseTimestamp(float64(1600000000123))
+		t.Assert(ok, true)
+		t.Assert(parsed.UnixNano()/1e6, int64(1600000000123))
+
+		parsed, ok = s.parseTimestamp(float64(1600000000))
+		t.Assert(ok, true)
+		t.Assert(parsed.Unix(), int64(1600000000))
+
+		// milliseconds are not taken for a distant expiry in seconds
+		_, ok = s.parseTimestamp(float64(1600000000123))
+		t.Assert(ok, false)
+
+		// but accepted in the compatibility mode, along with seconds
+		parsed, ok = compat.parseTimestamp(float64(1600000000123))
+		t.Assert(ok, true)
+		t.Assert(parsed.UnixNano()/1e6, int64(1600000000123))
+		parsed, ok = compat.parseTimestamp(float64(1600000000))
+		t.Assert(ok, true)
+		t.Assert(parsed.Unix(), int64(1600000000))
+
+		_, ok = s.parseTimestamp("1600000000")
+		t.Assert(ok, false)
+	})
+}
+
+func TestTimestampUnit_Middleware(t *testing.T) {
+	legacy := newTestMiddleware(&GfJWTMiddleware{})
+	second := newTestMiddleware(&GfJWTMiddleware{TimestampUnit: TimestampSecond})
+	compat := newTestMiddleware(&GfJWTMiddleware{TimestampUnit: TimestampSecondCompat})
+
+	servers := make(map[*GfJWTMiddleware]func(token string) int)
+	for _, mw := range []*GfJWTMiddleware{legacy, second, compat} {
+		c := newTestServer(t, func(s *ghttp.Server) {
+			s.Group("/", func(group *ghttp.RouterGroup) {
+				group.Middleware(authMiddleware(mw))
+				group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+			})
+		})
+		servers[mw] = func(token string) int {
+			resp, err := bearer(c, token).Get(ctx, "/hello")
+			if err != nil {
+				return 0
+			}
+			defer resp.Close()
+			return resp.StatusCode
+		}
+	}
+
+	gtest.C(t, func(t *gtest.T) {
+		inSeconds, _, err := second.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		inMilliseconds, _, err := legacy.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		// other JWT libraries read the expiry of second tokens correctly
+		now := time.Now()
+		claims := jwt.MapClaims(unverifiedClaims(inSeconds))
+		t.Assert(claims.VerifyExpiresAt(now.Unix(), true), true)
+		t.Assert(claims.VerifyExpiresAt(now.Add(2*time.Hour).Unix(), true), false)
+
+		t.Assert(servers[second](inSeconds), http.StatusOK)
+		t.Assert(servers[second](inMilliseconds), http.StatusBadRequest)
+		t.Assert(servers[compat](inSeconds), http.StatusOK)
+		t.Assert(servers[compat](inMilliseconds), http.StatusOK)
+		t.Assert(servers[legacy](inMilliseconds), http.StatusOK)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		// expired second tokens are rejected
+		issuer := newTestMiddleware(&GfJWTMiddleware{
+			TimestampUnit: TimestampSecond,
+			TimeFunc:      func() time.Time { return time.Now().Add(-2 * time.Hour) },
+		})
+		expired, _, err := issuer.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(servers[second](expired), http.StatusUnauthorized)
+		t.Assert(servers[compat](expired), http.StatusUnauthorized)
+	})
+}
+
+func TestValidateClaims(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		Issuer:   "issuer",
+		Audience: []string{"api", "web"},
+		Leeway:   30 * time.Second,
+		TimeFunc: clock.Now,
+	})
+	now := clock.Now().Unix()
+
+	gtest.C(t, func(t *gtest.T) {
+		valid := func() MapClaims {
+			return MapClaims{"iss": "issuer", "aud": "api", "nbf": now, "iat": now}
+		}
+		with := func(key string, value interface{}) MapClaims {
+			claims := valid()
+			if value == nil {
+				delete(claims, key)
+			} else {
+				claims[key] = value
+			}
+			return claims
+		}
+
+		t.AssertNil(mw.validateClaims(valid(), accessTokenType))
+		t.Assert(mw.validateClaims(valid(), refreshTokenType), ErrInvalidTokenType)
+
+		t.Assert(mw.validateClaims(with("iss", "other"), accessTokenType), ErrInvalidIssuer)
+		t.Assert(mw.validateClaims(with("iss", nil), accessTokenType), ErrInvalidIssuer)
+
+		// aud may be a string or an array, of which one audience must be accepted
+		t.AssertNil(mw.validateClaims(with("aud", []interface{}{"other", "web"}), accessTokenType))
+		t.Assert(mw.validateClaims(with("aud", []interface{}{"other"}), accessTokenType), ErrInvalidAudience)
+		t.Assert(mw.validateClaims(with("aud", "other"), accessTokenType), ErrInvalidAudience)
+		t.Assert(mw.validateClaims(with("aud", nil), accessTokenType), ErrInvalidAudience)
+
+		// nbf and iat may be ahead of the clock by the leeway, and are optional
+		t.AssertNil(mw.validateClaims(with("nbf", now+30), accessTokenType))
+		t.Assert(mw.validateClaims(with("nbf", now+31), accessTokenType), ErrTokenNotValidYet)
+		t.Assert(mw.validateClaims(with("nbf", "now"), accessTokenType), ErrTokenNotValidYet)
+		t.AssertNil(mw.validateClaims(with("nbf", nil), accessTokenType))
+
+		t.AssertNil(mw.validateClaims(with("iat", now+30), accessTokenType))
+		t.Assert(mw.validateClaims(with("iat", now+31), accessTokenType), ErrInvalidIssuedAt)
+		t.Assert(mw.validateClaims(with("iat", "now"), accessTokenType), ErrInvalidIssuedAt)
+		t.AssertNil(mw.validateClaims(with("iat", nil), accessTokenType))
+	})
+
+	// iss and aud are not required when they are not set
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTestMiddleware(&GfJWTMiddleware{TimeFunc: clock.Now})
+		t.AssertNil(mw.validateClaims(MapClaims{}, accessTokenType))
+		t.AssertNil(mw.validateClaims(MapClaims{"iss": "other", "aud": "other"}, accessTokenType))
+		t.Assert(mw.validateClaims(MapClaims{"nbf": now + 1}, accessTokenType), ErrTokenNotValidYet)
+	})
+}
+
+func TestNotBefore_Reissue(t *testing.T) {
+	clock := newTestClock()
+
+	// tokens refreshed within MaxRefresh
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTestMiddleware(&GfJWTMiddleware{
+			MaxRefresh: time.Hour,
+			NotBefore:  time.Minute,
+			TimeFunc:   clock.Now,
+		})
+		c := newSessionServer(t.T, mw)
+
+		token, _ := login(c, "a")
+		t.Assert(statusOf(c, token, "/hello"), http.StatusUnauthorized)
+		clock.Add(2 * time.Minute)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusOK)
+
+		refreshed := gconv.String(bearer(c, token).GetVar(ctx, "/refresh").Map()["token"])
+		t.AssertNE(refreshed, "")
+		t.Assert(unverifiedClaims(refreshed)["nbf"], unverifiedClaims(token)["nbf"])
+		t.Assert(statusOf(c, refreshed, "/hello"), http.StatusOK)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusUnauthorized)
+	})
+
+	// token pairs refreshed with a rotated refresh token
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTestMiddleware(&GfJWTMiddleware{
+			RefreshTokenTimeout:  time.Hour,
+			RefreshTokenRotation: true,
+			NotBefore:            time.Minute,
+			TimeFunc:             clock.Now,
+		})
+		c := newRefreshServer(t.T, mw)
+
+		login, err := mw.TokenPairGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(refresh(c, login.RefreshToken), nil)
+		clock.Add(2 * time.Minute)
+
+		refreshed := refresh(c, login.RefreshToken)
+		t.AssertNE(refreshed, nil)
+		t.AssertNil(mw.validateClaims(unverifiedClaims(refreshed.Token), accessTokenType))
+		t.AssertNE(refresh(c, refreshed.RefreshToken), nil)
+	})
+
+	// tokens slid within SlidingWindow
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTestMiddleware(&GfJWTMiddleware{
+			Timeout:       10 * time.Minute,
+			SlidingWindow: 5 * time.Minute,
+			NotBefore:     time.Minute,
+			TimeFunc:      clock.Now,
+		})
+		c := newTestServer(t.T, func(s *ghttp.Server) {
+			s.Group("/", func(group *ghttp.RouterGroup) {
+				group.Middleware(authMiddleware(mw))
+				group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+			})
+		})
+
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		clock.Add(6 * time.Minute)
+
+		reissued := slid(c, token)
+		t.AssertNE(reissued, "")
+		t.Assert(statusOf(c, reissued, "/hello"), http.StatusOK)
+	})
+}
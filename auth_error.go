@@ -1,6 +1,13 @@
 package jwt
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gogf/gf/v2/errors/gcode"
+)
 
 var (
 	// ErrMissingSecretKey indicates Secret key is required
@@ -45,7 +52,8 @@ var (
 	// ErrEmptyParamToken can be thrown if authing with parameter in path, the parameter in path is empty
 	ErrEmptyParamToken = errors.New("parameter token is empty")
 
-	// ErrInvalidSigningAlgorithm indicates signing algorithm is invalid, needs to be HS256, HS384, HS512, RS256, RS384 or RS512
+	// ErrInvalidSigningAlgorithm indicates signing algorithm is invalid, needs to be HS256, HS384, HS512, RS256, RS384, RS512,
+	// PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA
 	ErrInvalidSigningAlgorithm = errors.New("invalid signing algorithm")
 
 	// ErrNoPrivKeyFile indicates that the given private key is unreadable
@@ -68,4 +76,185 @@ var (
 
 	// ErrInvalidToken indicates JWT token has invalid. Can't refresh.
 	ErrInvalidToken = errors.New("token is invalid")
+
+	// ErrMissingKeyID indicates a key of the keyring has no ID
+	ErrMissingKeyID = errors.New("signing key id is required")
+
+	// ErrDuplicateKeyID indicates two keys of the keyring share the same ID
+	ErrDuplicateKeyID = errors.New("signing key id is duplicated")
+
+	// ErrUnknownKeyID indicates the kid header of the token matches no usable key
+	ErrUnknownKeyID = errors.New("token kid is unknown")
+
+	// ErrNoActiveSigningKey indicates no key of the keyring is active for signing
+	ErrNoActiveSigningKey = errors.New("no active signing key")
+
+	// ErrInvalidIssuer indicates the iss claim of the token is not the expected Issuer
+	ErrInvalidIssuer = errors.New("token issuer is invalid")
+
+	// ErrInvalidAudience indicates the aud claim of the token contains none of the expected Audience
+	ErrInvalidAudience = errors.New("token audience is invalid")
+
+	// ErrTokenNotValidYet indicates the nbf claim of the token is in the future
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+
+	// ErrInvalidIssuedAt indicates the iat claim of the token is in the future
+	ErrInvalidIssuedAt = errors.New("token used before issued")
+
+	// ErrInvalidTokenType indicates a refresh token is used as access token, or the other way round
+	ErrInvalidTokenType = errors.New("token type is invalid")
+
+	// ErrRefreshTokensDisabled indicates refresh tokens are used without RefreshTokenTimeout
+	ErrRefreshTokensDisabled = errors.New("refresh tokens are disabled")
+
+	// ErrLoginPairRequired indicates LoginHandler is used with RefreshTokenTimeout, whose refresh token it can not return
+	ErrLoginPairRequired = errors.New("refresh tokens require LoginPairHandler")
+
+	// ErrRefreshPairRequired indicates a refresh token is refreshed without receiving its rotated refresh token
+	ErrRefreshPairRequired = errors.New("refresh token rotation requires RefreshPairHandler")
+
+	// ErrRefreshTokenReused indicates a rotated refresh token is presented again, and its family has been revoked
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+	// ErrMissingClaims indicates the request carries no JWT claims
+	ErrMissingClaims = errors.New("claims are missing")
+
+	// ErrInvalidClaims indicates the JWT claims can not be decoded into the typed claims
+	ErrInvalidClaims = errors.New("claims are invalid")
+
+	// ErrFailedJWKSFetch indicates the remote JSON Web Key Set could not be fetched or decoded
+	ErrFailedJWKSFetch = errors.New("failed to fetch JWKS")
+
+	// ErrRevocationBackendUnavailable indicates the RevocationStore failed, so it is unknown whether the token has been revoked
+	ErrRevocationBackendUnavailable = errors.New("revocation backend is unavailable")
+
+	// ErrNonAtomicRevocationStore indicates RefreshTokenRotation or SlidingWindow is set with a RevocationStore
+	// whose SetIfNotExist is not atomic, such as one backed by the redis adapter of gcache
+	ErrNonAtomicRevocationStore = errors.New("revocation store must set values atomically")
+
+	// ErrMissingTokenStore indicates sessions are not tracked, as TokenStore is not set
+	ErrMissingTokenStore = errors.New("token store is required for sessions")
+
+	// ErrSessionNotFound indicates the session does not exist or does not belong to the identity
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrTooManySessions indicates the identity already holds MaxSessions sessions
+	ErrTooManySessions = errors.New("too many sessions")
+
+	// ErrSessionConflict indicates the session kept being changed concurrently while it was updated
+	ErrSessionConflict = errors.New("session changed concurrently")
+
+	// ErrIdleTimeout indicates the session of the token has not been used for IdleTimeout
+	ErrIdleTimeout = errors.New("session is idle")
+
+	// ErrInvalidPathPattern indicates a pattern of IncludePaths or ExcludePaths can not be compiled
+	ErrInvalidPathPattern = errors.New("invalid path pattern")
+
+	// ErrInsufficientScope indicates the token lacks scopes required by the resource
+	ErrInsufficientScope = errors.New("insufficient scope")
+
+	// ErrAmbiguousRoute indicates the route serving the request could not be told for global
+	// middleware, as routes with different g.Meta tags match it
+	ErrAmbiguousRoute = errors.New("route of the request is ambiguous")
+
+	// ErrRevokedToken indicates the token, or its identity, family or session, has been revoked.
+	// It wraps ErrInvalidToken, and has the same message. HTTPStatusMessageFunc is given ErrInvalidToken
+	// in its place
+	ErrRevokedToken = fmt.Errorf("%w", ErrInvalidToken)
+
+	// ErrPolicyDenied indicates the PolicyEnforcer denied the request, or failed to decide on it
+	ErrPolicyDenied = errors.New("access denied by policy")
 )
+
+// Error is the error of a request failing in the middleware, with the HTTP status, gcode code and
+// RFC 6750 error code of the failure. It wraps the error causing the failure, such as ErrExpiredToken,
+// and is the cause of the error of the request with SetRequestError.
+type Error struct {
+	// HTTP status of the response
+	Status int
+
+	// RFC 6750 error code of the WWW-Authenticate challenge, empty if the failure has none
+	BearerCode string
+
+	// Message of the failure, as given by HTTPStatusMessageFunc
+	Message string
+
+	// Error causing the failure
+	Err error
+
+	code gcode.Code
+}
+
+// Error returns the message of the failure.
+func (e *Error) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+// Code returns the gcode code of the failure, so that gerror.Code gives it.
+func (e *Error) Code() gcode.Code {
+	return e.code
+}
+
+// Unwrap returns the error causing the failure.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorCodes maps errors to gcode codes. Errors that are not listed take the code of their HTTP status.
+var errorCodes = []struct {
+	err  error
+	code gcode.Code
+}{
+	{ErrMissingSecretKey, gcode.CodeMissingConfiguration},
+	{ErrMissingAuthenticatorFunc, gcode.CodeMissingConfiguration},
+	{ErrMissingTokenStore, gcode.CodeMissingConfiguration},
+	{ErrNonAtomicRevocationStore, gcode.CodeInvalidConfiguration},
+	{ErrRefreshTokensDisabled, gcode.CodeNotSupported},
+	{ErrLoginPairRequired, gcode.CodeInvalidOperation},
+	{ErrRefreshPairRequired, gcode.CodeInvalidOperation},
+	{ErrMissingLoginValues, gcode.CodeMissingParameter},
+	{ErrFailedTokenCreation, gcode.CodeInternalError},
+}
+
+// errorCode returns the gcode code of a request failing with err and the HTTP status.
+func errorCode(status int, err error) gcode.Code {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	switch status {
+	case http.StatusBadRequest:
+		return gcode.CodeInvalidRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return gcode.CodeNotAuthorized
+	case http.StatusNotFound:
+		return gcode.CodeNotFound
+	case http.StatusServiceUnavailable:
+		return gcode.CodeServerBusy
+	default:
+		return gcode.CodeInternalError
+	}
+}
+
+// newError creates the Error of a request failing with err and the HTTP status.
+func (mw *GfJWTMiddleware) newError(ctx context.Context, status int, err error) *Error {
+	// HTTPStatusMessageFunc is given ErrInvalidToken for revoked tokens, as in earlier versions,
+	// so that callbacks comparing e == ErrInvalidToken still see them
+	messageErr := err
+	if errors.Is(err, ErrRevokedToken) {
+		messageErr = ErrInvalidToken
+	}
+
+	return &Error{
+		Status:     status,
+		BearerCode: bearerErrorCode(err),
+		Message:    mw.HTTPStatusMessageFunc(messageErr, ctx),
+		Err:        err,
+		code:       errorCode(status, err),
+	}
+}
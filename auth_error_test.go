@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+func TestUnauthorized_Status(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{})
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		resp, err := c.Get(ctx, "/hello")
+		t.AssertNil(err)
+		defer resp.Close()
+
+		t.Assert(resp.StatusCode, http.StatusUnauthorized)
+		body := resp.ReadAllString()
+		t.Assert(body, `{"code":401,"message":"`+ErrEmptyAuthHeader.Error()+`"}`)
+	})
+}
+
+type errorInfoReq struct {
+	g.Meta `method:"get"`
+}
+
+type errorInfoRes struct {
+	Identity interface{} `json:"identity"`
+}
+
+func TestSetRequestError(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{SetRequestError: true})
+	info := func(ctx context.Context, req *errorInfoReq) (*errorInfoRes, error) {
+		return &errorInfoRes{Identity: g.RequestFromCtx(ctx).GetParam(IdentityKey).Val()}, nil
+	}
+
+	// the middleware stack of the example
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(
+				func(r *ghttp.Request) {
+					r.Response.CORSDefault()
+					r.Middleware.Next()
+				},
+				ghttp.MiddlewareHandlerResponse,
+			)
+			group.Group("/", func(group *ghttp.RouterGroup) {
+				group.Middleware(authMiddleware(mw))
+				group.GET("/user/info", info)
+			})
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		resp, err := c.Get(ctx, "/user/info")
+		t.AssertNil(err)
+		defer resp.Close()
+
+		t.Assert(resp.StatusCode, http.StatusUnauthorized)
+		body := resp.ReadAllString()
+		t.Assert(body, fmt.Sprintf(`{"code":%d,"message":"%s","data":null}`, gcode.CodeNotAuthorized.Code(), ErrEmptyAuthHeader))
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		body := bearer(c, token).GetVar(ctx, "/user/info").Map()
+		t.Assert(body["code"], gcode.CodeOK.Code())
+		t.Assert(body["data"], g.Map{"identity": "a"})
+	})
+}
@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"context"
+	"time"
+)
+
+// checkIdle rejects tokens whose session has not been used for IdleTimeout. The last activity is
+// that of session, the session of the token in the TokenStore, which touchSession records.
+// Without TokenStore, checkIdle records the activity of the request itself: the last activity is
+// stored in the RevocationStore for IdleTimeout, and written at most once per SessionActivityInterval;
+// tokens without activity count from their issue.
+func (mw *GfJWTMiddleware) checkIdle(ctx context.Context, claims MapClaims, session *Session) error {
+	if mw.IdleTimeout <= 0 {
+		return nil
+	}
+
+	now := mw.TimeFunc()
+	if session != nil {
+		if mw.idle(session.LastSeen, now) {
+			return ErrIdleTimeout
+		}
+		return nil
+	}
+
+	// refresh tokens are checked as well, so that an idle session can not be revived by refreshing;
+	// one that shares no ID with its access tokens counts from its own issue
+	id := activityID(claims)
+	if id == "" {
+		return ErrInvalidToken
+	}
+
+	key := mw.BlacklistPrefix + "ACTIVITY:" + id
+
+	lastSeen, ok := mw.issuedAt(claims)
+	v, err := mw.revocationStore.Get(ctx, key)
+	if err != nil {
+		return mw.lookupFailure(ctx, err)
+	}
+	if v != nil && !v.IsNil() {
+		lastSeen, ok = time.Unix(v.Int64(), 0), true
+	}
+	if !ok || mw.idle(lastSeen, now) {
+		return ErrIdleTimeout
+	}
+
+	if now.Sub(lastSeen) < mw.SessionActivityInterval {
+		return nil
+	}
+	if err = mw.revocationStore.Set(ctx, key, now.Unix(), mw.IdleTimeout+mw.Leeway); err != nil {
+		return mw.lookupFailure(ctx, err)
+	}
+	return nil
+}
+
+// idle reports whether a session last used at lastSeen has timed out at now.
+func (mw *GfJWTMiddleware) idle(lastSeen time.Time, now time.Time) bool {
+	return mw.IdleTimeout > 0 && now.Sub(lastSeen) > mw.IdleTimeout+mw.Leeway
+}
+
+// issuedAt returns the time the token was issued, from "iat" or else "orig_iat".
+func (mw *GfJWTMiddleware) issuedAt(claims MapClaims) (time.Time, bool) {
+	if iat, ok := numericDate(claims["iat"]); ok {
+		return time.Unix(iat, 0), true
+	}
+	return mw.parseTimestamp(claims["orig_iat"])
+}
+
+// activityID returns the ID under which the activity of a token is tracked: its session or family,
+// which is shared with the tokens issued on refresh, or else its jti.
+func activityID(claims MapClaims) string {
+	if sid, _ := claims[sessionClaim].(string); sid != "" {
+		return sid
+	}
+	if family, _ := claims[familyClaim].(string); family != "" {
+		return family
+	}
+	jti, _ := claims["jti"].(string)
+	return jti
+}
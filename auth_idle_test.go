@@ -0,0 +1,93 @@
+package jwt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+func TestIdleTimeout_Refresh(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		Timeout:             time.Hour,
+		RefreshTokenTimeout: 24 * time.Hour,
+		IdleTimeout:         10 * time.Minute,
+		TimeFunc:            clock.Now,
+	})
+	c := newRefreshServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		login, err := mw.TokenPairGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		// the tokens share their activity without TokenStore or rotation
+		sid := unverifiedClaims(login.Token)[sessionClaim]
+		t.AssertNE(sid, nil)
+		t.Assert(unverifiedClaims(login.RefreshToken)[sessionClaim], sid)
+
+		clock.Add(5 * time.Minute)
+		refreshed := refresh(c, login.RefreshToken)
+		t.AssertNE(refreshed, nil)
+
+		// an idle session can not be revived by refreshing
+		clock.Add(11 * time.Minute)
+		t.Assert(refresh(c, login.RefreshToken), nil)
+		t.Assert(refresh(c, refreshed.RefreshToken), nil)
+	})
+}
+
+func TestIdleTimeout_Sessions(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		TokenStore:  NewMemoryTokenStore(),
+		MaxRefresh:  time.Hour,
+		IdleTimeout: 10 * time.Minute,
+		TimeFunc:    clock.Now,
+	})
+	c := newSessionServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(mw.SessionActivityInterval, time.Minute)
+		t.Assert(newTestMiddleware(&GfJWTMiddleware{IdleTimeout: 5 * time.Minute}).SessionActivityInterval, 30*time.Second)
+
+		token, _ := login(c, "a")
+		sid := unverifiedClaims(token)[sessionClaim].(string)
+
+		// the activity is that of the session, which is the only one tracked
+		clock.Add(9 * time.Minute)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusOK)
+		clock.Add(9 * time.Minute)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusOK)
+		in, err := mw.RevocationStore.Contains(ctx, mw.BlacklistPrefix+"ACTIVITY:"+sid)
+		t.AssertNil(err)
+		t.Assert(in, false)
+
+		clock.Add(11 * time.Minute)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusUnauthorized)
+		t.Assert(statusOf(c, token, "/refresh"), http.StatusUnauthorized)
+
+		// idle sessions can be logged out
+		t.Assert(statusOf(c, token, "/logout"), http.StatusOK)
+		session, err := mw.TokenStore.Get(ctx, sid)
+		t.AssertNil(err)
+		t.AssertNil(session)
+	})
+
+	// and are not listed, even if they are not logged out
+	gtest.C(t, func(t *gtest.T) {
+		idle, _ := login(c, "b")
+		clock.Add(5 * time.Minute)
+		active, _ := login(c, "b")
+		clock.Add(6 * time.Minute)
+
+		sessions, err := mw.ListSessions(ctx, "b")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 1)
+		t.Assert(sessions[0].ID, unverifiedClaims(active)[sessionClaim])
+		session, err := mw.TokenStore.Get(ctx, unverifiedClaims(idle)[sessionClaim].(string))
+		t.AssertNil(err)
+		t.AssertNil(session)
+	})
+}
@@ -0,0 +1,131 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// JSONWebKey is a public key in the JSON Web Key format of RFC 7517.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a set of public keys in the JSON Web Key Set format of RFC 7517.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public verification keys of the middleware as a JSON Web Key Set.
+// Keys of the keyring are included before their activation, so that verifiers can
+// fetch them ahead of a rollover. HMAC secrets are never published.
+func (mw *GfJWTMiddleware) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	if mw.usingPublicKeyAlgo() && mw.pubKey != nil {
+		if jwk, err := newJSONWebKey(mw.pubKey, mw.KeyID, mw.SigningAlgorithm); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	for _, key := range mw.Keys {
+		if !isPublicKeyAlgo(key.Algorithm) || key.pubKey == nil {
+			continue
+		}
+		if jwk, err := newJSONWebKey(key.pubKey, key.ID, key.Algorithm); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+// JWKSHandler serves the public verification keys of the middleware as a JSON Web Key Set.
+// It is usually bound to "/.well-known/jwks.json".
+func (mw *GfJWTMiddleware) JWKSHandler(r *ghttp.Request) {
+	body, err := json.Marshal(mw.JWKS())
+	if err != nil {
+		r.Response.WriteStatus(http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + gmd5.MustEncryptBytes(body) + `"`
+	r.Response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(mw.JWKSMaxAge.Seconds())))
+	r.Response.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		r.Response.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	r.Response.Header().Set("Content-Type", "application/jwk-set+json")
+	r.Response.Write(body)
+}
+
+// newJSONWebKey encodes a public key as a JSON Web Key. The key ID defaults to
+// the RFC 7638 thumbprint of the key if kid is empty.
+func newJSONWebKey(key crypto.PublicKey, kid string, alg string) (JSONWebKey, error) {
+	jwk := JSONWebKey{
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	default:
+		return JSONWebKey{}, ErrInvalidPubKey
+	}
+
+	if jwk.Kid == "" {
+		jwk.Kid = jwk.thumbprint()
+	}
+
+	return jwk, nil
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the key.
+func (jwk JSONWebKey) thumbprint() string {
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.Kty, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Crv, jwk.Kty, jwk.X, jwk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Crv, jwk.Kty, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
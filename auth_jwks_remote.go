@@ -0,0 +1,274 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/os/gcache"
+)
+
+// remoteKeySetCacheKey is the cache key of the fetched key set.
+const remoteKeySetCacheKey = "jwks"
+
+// remoteKey is a verification key of a remote JSON Web Key Set.
+type remoteKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// remoteKeySet fetches and caches the JSON Web Key Set served at url.
+type remoteKeySet struct {
+	url             string
+	client          *gclient.Client
+	cache           *gcache.Cache
+	ttl             time.Duration
+	refetchInterval time.Duration
+	timeout         time.Duration
+
+	mu        sync.Mutex
+	lastFetch time.Time
+}
+
+// newRemoteKeySet creates a remoteKeySet from the JWKS settings of the middleware.
+func newRemoteKeySet(mw *GfJWTMiddleware) *remoteKeySet {
+	return &remoteKeySet{
+		url:             mw.JWKSURL,
+		client:          mw.JWKSClient,
+		cache:           gcache.New(),
+		ttl:             mw.JWKSCacheTTL,
+		refetchInterval: mw.JWKSRefetchInterval,
+		timeout:         mw.JWKSFetchTimeout,
+	}
+}
+
+// newJWKSClient creates the default client of remote key sets, which verifies the TLS
+// certificate of the endpoint, unlike gclient.New, and gives up after timeout.
+func newJWKSClient(timeout time.Duration) *gclient.Client {
+	client := gclient.New()
+	client.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	client.SetTimeout(timeout)
+	return client
+}
+
+// verificationKey returns the remote key selected by kid, or by alg if kid is empty.
+// The key set is fetched again if kid is unknown, at most once per refetch interval.
+func (s *remoteKeySet) verificationKey(ctx context.Context, kid string, alg string) (interface{}, error) {
+	keys, err := s.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := selectRemoteKey(keys, kid, alg); key != nil {
+		return key, nil
+	}
+
+	if kid == "" {
+		return nil, ErrUnknownKeyID
+	}
+
+	keys, err = s.refetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := selectRemoteKey(keys, kid, alg); key != nil {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKeyID
+}
+
+// keys returns the cached key set, fetching it if the cache has expired.
+func (s *remoteKeySet) keys(ctx context.Context) ([]remoteKey, error) {
+	if v, err := s.cache.Get(ctx, remoteKeySetCacheKey); err == nil && !v.IsNil() {
+		return v.Val().([]remoteKey), nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another request may have fetched the key set meanwhile
+	if v, err := s.cache.Get(ctx, remoteKeySetCacheKey); err == nil && !v.IsNil() {
+		return v.Val().([]remoteKey), nil
+	}
+
+	// do not hammer an unavailable endpoint; the wall clock is used as by the cache expiry,
+	// not TimeFunc, which may be frozen
+	if time.Since(s.lastFetch) < s.refetchInterval {
+		return nil, ErrFailedJWKSFetch
+	}
+
+	return s.fetch(ctx)
+}
+
+// refetch fetches the key set again, unless it was fetched within the refetch interval.
+func (s *remoteKeySet) refetch(ctx context.Context) ([]remoteKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastFetch) < s.refetchInterval {
+		if v, err := s.cache.Get(ctx, remoteKeySetCacheKey); err == nil && !v.IsNil() {
+			return v.Val().([]remoteKey), nil
+		}
+		return nil, nil
+	}
+
+	return s.fetch(ctx)
+}
+
+// fetch downloads the key set and caches it for the duration given by the Cache-Control
+// header of the response. The caller must hold s.mu. The fetch is detached from the request
+// that triggers it, and bounded by the fetch timeout, as every verification waits for it.
+func (s *remoteKeySet) fetch(ctx context.Context) ([]remoteKey, error) {
+	s.lastFetch = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, s.url)
+	if err != nil {
+		return nil, ErrFailedJWKSFetch
+	}
+	defer resp.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrFailedJWKSFetch
+	}
+
+	var set JSONWebKeySet
+	if err = json.Unmarshal(resp.ReadAll(), &set); err != nil {
+		return nil, ErrFailedJWKSFetch
+	}
+
+	keys := make([]remoteKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, remoteKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+
+	ttl := cacheMaxAge(resp.Header.Get("Cache-Control"), s.ttl)
+	if ttl < s.refetchInterval {
+		ttl = s.refetchInterval
+	}
+	if err = s.cache.Set(ctx, remoteKeySetCacheKey, keys, ttl); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// selectRemoteKey returns the key matching kid, or the first key usable with alg if kid is empty.
+func selectRemoteKey(keys []remoteKey, kid string, alg string) interface{} {
+	for _, key := range keys {
+		if kid != "" && key.kid != kid {
+			continue
+		}
+		if key.alg != "" && key.alg != alg {
+			continue
+		}
+		if !keyMatchesAlgorithm(alg, key.key) {
+			continue
+		}
+		return key.key
+	}
+	return nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header, or def if the header has none.
+// Responses that must not be cached yield zero.
+func cacheMaxAge(cacheControl string, def time.Duration) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store", directive == "no-cache":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64)
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return def
+}
+
+// publicKey decodes the public key of a JSON Web Key.
+func (jwk JSONWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, ErrInvalidPubKey
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidPubKey
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrInvalidPubKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, ErrInvalidPubKey
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, ErrInvalidPubKey
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrInvalidPubKey
+		}
+		return key, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, ErrInvalidPubKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidPubKey
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, ErrInvalidPubKey
+}
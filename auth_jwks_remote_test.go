@@ -0,0 +1,217 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+// jwksStandIn serves the JWKS of an issuer, counting the fetches.
+type jwksStandIn struct {
+	mu           sync.Mutex
+	issuer       *GfJWTMiddleware
+	cacheControl string
+	fetches      int32
+}
+
+func (s *jwksStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&s.fetches, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cacheControl != "" {
+		w.Header().Set("Cache-Control", s.cacheControl)
+	}
+	_ = json.NewEncoder(w).Encode(s.issuer.JWKS())
+}
+
+func (s *jwksStandIn) setIssuer(issuer *GfJWTMiddleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issuer = issuer
+}
+
+func newTestIssuer(t *testing.T, kid string) *GfJWTMiddleware {
+	return newTestMiddleware(&GfJWTMiddleware{
+		SigningAlgorithm: "ES256",
+		KeyID:            kid,
+		PrivKeyBytes:     newECKeyPEM(t),
+	})
+}
+
+func TestRemoteJWKS_FetchAndCache(t *testing.T) {
+	standIn := &jwksStandIn{issuer: newTestIssuer(t, "k1")}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	verifier := newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: "ES256", JWKSURL: server.URL})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		for i := 0; i < 3; i++ {
+			parsed, err := verifier.parseTokenString(ctx, token)
+			t.AssertNil(err)
+			t.Assert(parsed.Valid, true)
+		}
+		t.Assert(atomic.LoadInt32(&standIn.fetches), 1)
+	})
+}
+
+func TestRemoteJWKS_RefetchOnUnknownKid(t *testing.T) {
+	standIn := &jwksStandIn{issuer: newTestIssuer(t, "k1")}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	gtest.C(t, func(t *gtest.T) {
+		verifier := newTestMiddleware(&GfJWTMiddleware{
+			SigningAlgorithm:    "ES256",
+			JWKSURL:             server.URL,
+			JWKSRefetchInterval: time.Nanosecond,
+		})
+		old, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+		_, err := verifier.parseTokenString(ctx, old)
+		t.AssertNil(err)
+
+		standIn.setIssuer(newTestIssuer(t.T, "k2"))
+		token, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+		_, err = verifier.parseTokenString(ctx, token)
+		t.AssertNil(err)
+		t.Assert(atomic.LoadInt32(&standIn.fetches), 2)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		atomic.StoreInt32(&standIn.fetches, 0)
+		standIn.setIssuer(newTestIssuer(t.T, "k1"))
+		verifier := newTestMiddleware(&GfJWTMiddleware{
+			SigningAlgorithm:    "ES256",
+			JWKSURL:             server.URL,
+			JWKSRefetchInterval: time.Hour,
+		})
+		old, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+		_, err := verifier.parseTokenString(ctx, old)
+		t.AssertNil(err)
+
+		// unknown kids do not refetch within the refetch interval
+		standIn.setIssuer(newTestIssuer(t.T, "k2"))
+		for i := 0; i < 3; i++ {
+			token, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+			_, err = verifier.parseTokenString(ctx, token)
+			t.AssertNE(err, nil)
+		}
+		t.Assert(atomic.LoadInt32(&standIn.fetches), 1)
+	})
+}
+
+func TestRemoteJWKS_CacheControl(t *testing.T) {
+	standIn := &jwksStandIn{issuer: newTestIssuer(t, "k1"), cacheControl: "public, max-age=1"}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	// TimeFunc is frozen, and must not hold back the fetch once the cache expires
+	frozen := time.Now()
+	verifier := newTestMiddleware(&GfJWTMiddleware{
+		SigningAlgorithm:    "ES256",
+		JWKSURL:             server.URL,
+		JWKSRefetchInterval: 500 * time.Millisecond,
+		TimeFunc:            func() time.Time { return frozen },
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+		_, err := verifier.parseTokenString(ctx, token)
+		t.AssertNil(err)
+		_, err = verifier.parseTokenString(ctx, token)
+		t.AssertNil(err)
+		t.Assert(atomic.LoadInt32(&standIn.fetches), 1)
+
+		time.Sleep(1200 * time.Millisecond)
+		_, err = verifier.parseTokenString(ctx, token)
+		t.AssertNil(err)
+		t.Assert(atomic.LoadInt32(&standIn.fetches), 2)
+	})
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(cacheMaxAge("public, max-age=60", time.Hour), time.Minute)
+		t.Assert(cacheMaxAge("MAX-AGE=5", time.Hour), 5*time.Second)
+		t.Assert(cacheMaxAge("no-store", time.Hour), time.Duration(0))
+		t.Assert(cacheMaxAge("no-cache, max-age=60", time.Hour), time.Duration(0))
+		t.Assert(cacheMaxAge("", time.Hour), time.Hour)
+		t.Assert(cacheMaxAge("max-age=abc", time.Hour), time.Hour)
+	})
+}
+
+func TestRemoteJWKS_TLS(t *testing.T) {
+	standIn := &jwksStandIn{issuer: newTestIssuer(t, "k1")}
+	server := httptest.NewTLSServer(standIn)
+	defer server.Close()
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+
+		// the certificate of the endpoint is not trusted by the default client
+		verifier := newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: "ES256", JWKSURL: server.URL})
+		_, err := verifier.parseTokenString(ctx, token)
+		t.Assert(errors.Is(err, ErrFailedJWKSFetch), true)
+		t.Assert(atomic.LoadInt32(&standIn.fetches), 0)
+
+		// but is by a client given its certificate
+		client := gclient.New()
+		client.Transport = server.Client().Transport
+		verifier = newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: "ES256", JWKSURL: server.URL, JWKSClient: client})
+		_, err = verifier.parseTokenString(ctx, token)
+		t.AssertNil(err)
+	})
+}
+
+func TestRemoteJWKS_Timeout(t *testing.T) {
+	standIn := &jwksStandIn{issuer: newTestIssuer(t, "k1")}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		standIn.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	verifier := newTestMiddleware(&GfJWTMiddleware{
+		SigningAlgorithm: "ES256",
+		JWKSURL:          server.URL,
+		JWKSFetchTimeout: 100 * time.Millisecond,
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+
+		// a hung endpoint does not hold verification up beyond the fetch timeout
+		start := time.Now()
+		_, err := verifier.parseTokenString(ctx, token)
+		t.Assert(errors.Is(err, ErrFailedJWKSFetch), true)
+		t.Assert(time.Since(start) < time.Second, true)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		standIn := &jwksStandIn{issuer: newTestIssuer(t.T, "k1")}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		verifier := newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: "ES256", JWKSURL: server.URL})
+		token, _, _ := standIn.issuer.TokenGenerator(MapClaims{"identity": "a"})
+
+		// the fetch does not fail with the request that triggers it
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := verifier.parseTokenString(canceled, token)
+		t.AssertNil(err)
+	})
+}
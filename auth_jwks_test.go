@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newJWKSServer serves the JWKSHandler of mw at /jwks.
+func newJWKSServer(t *testing.T, mw *GfJWTMiddleware) *gclient.Client {
+	return newTestServer(t, func(s *ghttp.Server) {
+		s.BindHandler("/jwks", mw.JWKSHandler)
+	})
+}
+
+// kids returns the key IDs of the set, in order.
+func kids(set JSONWebKeySet) []string {
+	ids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		ids = append(ids, key.Kid)
+	}
+	return ids
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, test := range []struct {
+		alg string
+		key crypto.PrivateKey
+		kty string
+		crv string
+	}{
+		{"RS256", rsaKey, "RSA", ""},
+		{"ES256", p256, "EC", "P-256"},
+		{"EdDSA", edKey, "OKP", "Ed25519"},
+	} {
+		gtest.C(t, func(t *gtest.T) {
+			mw := newTestMiddleware(&GfJWTMiddleware{
+				SigningAlgorithm: test.alg,
+				KeyID:            "key-1",
+				PrivKeyBytes:     newKeyPEM(t.T, test.key),
+			})
+
+			set := mw.JWKS()
+			t.Assert(len(set.Keys), 1)
+			jwk := set.Keys[0]
+			t.Assert(jwk.Kty, test.kty)
+			t.Assert(jwk.Crv, test.crv)
+			t.Assert(jwk.Kid, "key-1")
+			t.Assert(jwk.Alg, test.alg)
+			t.Assert(jwk.Use, "sig")
+
+			// the published key verifies the tokens of the middleware
+			token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+			t.AssertNil(err)
+			_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+				return jwk.publicKey()
+			})
+			t.AssertNil(err)
+		})
+	}
+
+	// keys without ID are identified by their thumbprint
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: "ES256", PrivKeyBytes: newKeyPEM(t.T, p256)})
+		jwk := mw.JWKS().Keys[0]
+		t.AssertNE(jwk.Kid, "")
+		t.Assert(jwk.Kid, jwk.thumbprint())
+
+		// the thumbprint of the example of RFC 7638
+		rfc := JSONWebKey{
+			Kty: "RSA",
+			E:   "AQAB",
+			N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		}
+		t.Assert(rfc.thumbprint(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs")
+	})
+
+	// HMAC secrets are never published
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(len(newTestMiddleware(&GfJWTMiddleware{}).JWKS().Keys), 0)
+		t.Assert(len(newTestMiddleware(&GfJWTMiddleware{Keys: []*SigningKey{{ID: "k1", Key: []byte("key one")}}}).JWKS().Keys), 0)
+	})
+}
+
+func TestJWKS_Keyring(t *testing.T) {
+	clock := newTestClock()
+	start := clock.Now()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		SigningAlgorithm: "ES256",
+		TimeFunc:         clock.Now,
+		Keys: []*SigningKey{
+			{ID: "k1", PrivKeyBytes: newECKeyPEM(t), RetireAt: start.Add(time.Hour)},
+			{ID: "k2", PrivKeyBytes: newECKeyPEM(t), ActivateAt: start.Add(time.Hour)},
+		},
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		// keys are published ahead of their activation
+		before := mw.JWKS()
+		t.Assert(kids(before), []string{"k1", "k2"})
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		// and after their retirement, as long as the tokens they signed are outstanding
+		clock.Add(2 * time.Hour)
+		after := mw.JWKS()
+		t.Assert(after, before)
+
+		_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+			t.Assert(token.Header["kid"], after.Keys[0].Kid)
+			return after.Keys[0].publicKey()
+		})
+		t.AssertNil(err)
+	})
+}
+
+func TestJWKSHandler(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		SigningAlgorithm: "ES256",
+		KeyID:            "key-1",
+		PrivKeyBytes:     newECKeyPEM(t),
+		JWKSMaxAge:       10 * time.Minute,
+	})
+	c := newJWKSServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		resp, err := c.Get(ctx, "/jwks")
+		t.AssertNil(err)
+		defer resp.Close()
+
+		t.Assert(resp.StatusCode, http.StatusOK)
+		t.Assert(resp.Header.Get("Content-Type"), "application/jwk-set+json")
+		t.Assert(resp.Header.Get("Cache-Control"), "public, max-age=600")
+		etag := resp.Header.Get("ETag")
+		t.AssertNE(etag, "")
+
+		var set JSONWebKeySet
+		t.AssertNil(json.Unmarshal(resp.ReadAll(), &set))
+		t.Assert(set, mw.JWKS())
+
+		// the set is not sent again while it is unchanged
+		resp, err = c.Header(map[string]string{"If-None-Match": etag}).Get(ctx, "/jwks")
+		t.AssertNil(err)
+		defer resp.Close()
+		t.Assert(resp.StatusCode, http.StatusNotModified)
+		t.Assert(resp.Header.Get("ETag"), etag)
+		t.Assert(len(resp.ReadAll()), 0)
+
+		resp, err = c.Header(map[string]string{"If-None-Match": `"stale"`}).Get(ctx, "/jwks")
+		t.AssertNil(err)
+		defer resp.Close()
+		t.Assert(resp.StatusCode, http.StatusOK)
+	})
+
+	// the ETag follows the keys
+	gtest.C(t, func(t *gtest.T) {
+		other := newJWKSServer(t.T, newTestMiddleware(&GfJWTMiddleware{
+			SigningAlgorithm: "ES256",
+			KeyID:            "key-1",
+			PrivKeyBytes:     newECKeyPEM(t.T),
+		}))
+		resp, err := c.Get(ctx, "/jwks")
+		t.AssertNil(err)
+		defer resp.Close()
+		otherResp, err := other.Get(ctx, "/jwks")
+		t.AssertNil(err)
+		defer otherResp.Close()
+
+		t.AssertNE(resp.Header.Get("ETag"), otherResp.Header.Get("ETag"))
+		t.Assert(otherResp.Header.Get("Cache-Control"), "public, max-age=3600")
+	})
+}
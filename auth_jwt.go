@@ -2,16 +2,21 @@ package jwt
 
 import (
 	"context"
-	"crypto/rsa"
-	"io/ioutil"
+	"crypto"
+	"errors"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/os/gmlock"
+	"github.com/gogf/gf/v2/util/guid"
 	"github.com/golang-jwt/jwt/v4"
 )
 
@@ -28,26 +33,109 @@ type GfJWTMiddleware struct {
 	// Realm name to display to the user. Required.
 	Realm string
 
-	// signing algorithm - possible values are HS256, HS384, HS512, RS256, RS384 or RS512
+	// signing algorithm - possible values are HS256, HS384, HS512, RS256, RS384, RS512,
+	// PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA
 	// Optional, default is HS256.
 	SigningAlgorithm string
 
 	// Secret key used for signing. Required.
 	Key []byte
 
+	// ID of Key or the key pair, written to the "kid" header of signed tokens and
+	// published by JWKSHandler. Optional, by default no "kid" header is written.
+	KeyID string
+
 	// Callback to retrieve key used for signing. Setting KeyFunc will bypass
 	// all other key settings
 	KeyFunc func(token *jwt.Token) (interface{}, error)
 
+	// URL of a remote JSON Web Key Set used to verify tokens, e.g. "https://issuer/.well-known/jwks.json".
+	// Tokens are verified with the remote key selected by "kid", or by "alg" if the token has no "kid".
+	// Optional, Key and the key pair are not required if JWKSURL is set.
+	JWKSURL string
+
+	// Duration that the remote key set is cached if its response has no Cache-Control max-age.
+	// Optional, defaults to one hour.
+	JWKSCacheTTL time.Duration
+
+	// Minimum duration between two fetches of the remote key set, which is fetched again
+	// when a token has an unknown "kid". Optional, defaults to one minute.
+	JWKSRefetchInterval time.Duration
+
+	// Client used to fetch the remote key set. Optional, defaults to a client verifying the TLS
+	// certificate of the endpoint, which g.Client() does not.
+	JWKSClient *gclient.Client
+
+	// Maximum duration of a fetch of the remote key set. Fetches are not bound to the request
+	// that triggers them, so that a client going away does not fail them.
+	// Optional, defaults to 10 seconds.
+	JWKSFetchTimeout time.Duration
+
 	// Duration that a jwt token is valid. Optional, defaults to one hour.
 	Timeout time.Duration
 
+	// Issuer written to the "iss" claim, and required in the "iss" claim of verified tokens.
+	// Optional, by default "iss" is neither set nor checked.
+	Issuer string
+
+	// Audience written to the "aud" claim. Verified tokens must have one of these audiences.
+	// Optional, by default "aud" is neither set nor checked.
+	Audience []string
+
+	// Callback function that maps the identity of a token to its "sub" claim.
+	// Optional, by default no "sub" claim is set.
+	SubjectFunc func(identity interface{}) string
+
+	// Duration after login that a token becomes valid, written to the "nbf" claim. Tokens issued
+	// by refreshing or sliding keep the "nbf" of the token they replace.
+	// Optional, defaults to 0 meaning the token is valid once issued.
+	NotBefore time.Duration
+
+	// Tolerance for clock skew between servers when checking "exp", "nbf" and "iat".
+	// Optional, defaults to 0.
+	Leeway time.Duration
+
+	// Unit of the "exp" and "orig_iat" claims. TimestampSecond follows RFC 7519, so that other
+	// JWT libraries read the expiry correctly. TimestampSecondCompat issues seconds and also accepts
+	// tokens in milliseconds, for the transition window of a migration.
+	// Optional, defaults to TimestampMillisecond for compatibility with earlier versions.
+	TimestampUnit TimestampUnit
+
 	// This field allows clients to refresh their token until MaxRefresh has passed.
 	// Note that clients can refresh their token in the last moment of MaxRefresh.
 	// This means that the maximum validity timespan for a token is TokenTime + MaxRefresh.
 	// Optional, defaults to 0 meaning not refreshable.
 	MaxRefresh time.Duration
 
+	// Duration that a refresh token is valid. Setting it enables refresh tokens: LoginPairHandler
+	// issues a refresh token alongside the access token, RefreshHandler only accepts refresh tokens,
+	// and the middleware rejects them. MaxRefresh no longer applies to access tokens.
+	// Optional, defaults to 0 meaning the access token itself is refreshed within MaxRefresh.
+	RefreshTokenTimeout time.Duration
+
+	// Secret key used for signing refresh tokens with RefreshSigningAlgorithm.
+	// Optional, by default refresh tokens are signed like access tokens.
+	RefreshKey []byte
+
+	// signing algorithm of RefreshKey - possible values are HS256, HS384 or HS512
+	// Optional, default is HS256.
+	RefreshSigningAlgorithm string
+
+	// Rotate refresh tokens: every refresh issues a new refresh token and invalidates the old one.
+	// Presenting a rotated refresh token again revokes every token of its family, which are the
+	// tokens descending from the same login. RefreshPairHandler must be used to receive the new
+	// refresh token. Requires RefreshTokenTimeout.
+	RefreshTokenRotation bool
+
+	// Callback function that is called when a rotated refresh token is presented again,
+	// with the family that has been revoked. Optional, for alerting.
+	RefreshTokenReuseHandler func(ctx context.Context, family string, claims MapClaims)
+
+	// RefreshTokenLookup is a string in the form of "<source>:<name>" that is used
+	// to extract refresh token from the request, see TokenLookup.
+	// Optional. Default value "query:refresh_token".
+	RefreshTokenLookup string
+
 	// Callback function that should perform the authentication of the user based on login info.
 	// Must return user data as user identifier, it will be stored in Claim Array. Required.
 	// Check error (e) to determine the appropriate error message.
@@ -69,12 +157,29 @@ type GfJWTMiddleware struct {
 	// User can define own Unauthorized func.
 	Unauthorized func(ctx context.Context, code int, message string)
 
+	// ProblemDetails tells when the default Unauthorized responds with RFC 7807 Problem Details,
+	// as ProblemUnauthorized does, instead of {"code", "message"}.
+	// Optional, defaults to ProblemDetailsNever.
+	ProblemDetails ProblemDetailsMode
+
 	// Set the identity handler function
 	IdentityHandler func(ctx context.Context) interface{}
 
 	// Set the identity key
 	IdentityKey string
 
+	// Policy deciding whether requests are authorized, given the route they are for. It is asked
+	// after Authorizator. Optional, requests are only authorized by Authorizator if not set.
+	PolicyEnforcer PolicyEnforcer
+
+	// Claim holding the roles of the identity, as a space-delimited string or an array of strings.
+	// Optional, defaults to "roles".
+	RolesKey string
+
+	// Claim holding the scopes of the token, as a space-delimited string or an array of strings.
+	// Optional, defaults to "scope".
+	ScopesKey string
+
 	// TokenLookup is a string in the form of "<source>:<name>" that is used
 	// to extract token from the request.
 	// Optional. Default value "header:Authorization".
@@ -87,14 +192,19 @@ type GfJWTMiddleware struct {
 	// TokenHeadName is a string in the header. Default value is "Bearer"
 	TokenHeadName string
 
+	// LegacyChallenge sets the WWW-Authenticate header of every failure to "JWT realm=Realm",
+	// instead of the RFC 6750 Bearer challenges with error codes.
+	LegacyChallenge bool
+
 	// TimeFunc provides the current time. You can override it to use another time value. This is useful for testing or if your server uses a different time zone than your tokens.
 	TimeFunc func() time.Time
 
 	// HTTP Status messages for when something in the JWT middleware fails.
-	// Check error (e) to determine the appropriate error message.
+	// Check error (e) to determine the appropriate error message. Revoked tokens are given as
+	// ErrInvalidToken, not ErrRevokedToken.
 	HTTPStatusMessageFunc func(e error, ctx context.Context) string
 
-	// Private key file for asymmetric algorithms
+	// Private key file for asymmetric algorithms, PEM encoded in PKCS#1, PKCS#8 or SEC 1 form
 	PrivKeyFile string
 
 	// Private Key bytes for asymmetric algorithms
@@ -102,7 +212,8 @@ type GfJWTMiddleware struct {
 	// Note: PrivKeyFile takes precedence over PrivKeyBytes if both are set
 	PrivKeyBytes []byte
 
-	// Public key file for asymmetric algorithms
+	// Public key file for asymmetric algorithms, PEM encoded in PKIX or PKCS#1 form, or a certificate.
+	// Optional, derived from the private key when neither PubKeyFile nor PubKeyBytes is set.
 	PubKeyFile string
 
 	// Private key passphrase
@@ -113,11 +224,29 @@ type GfJWTMiddleware struct {
 	// Note: PubKeyFile takes precedence over PubKeyBytes if both are set
 	PubKeyBytes []byte
 
-	// Private key
-	privKey *rsa.PrivateKey
+	// Private key, one of *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
+	privKey crypto.PrivateKey
+
+	// Public key, one of *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	pubKey crypto.PublicKey
+
+	// Keys is the keyring used for key rotation. New tokens are signed with the active key, and
+	// its ID is written to the "kid" header. Tokens are verified with the key selected by "kid".
+	// Tokens without "kid" are verified with Key or the public key, if they are set.
+	// Optional, by default only Key or the key pair is used.
+	Keys []*SigningKey
+
+	// Duration that clients may cache the key set served by JWKSHandler. Optional, defaults to one hour.
+	JWKSMaxAge time.Duration
+
+	// Remote key set fetched from JWKSURL
+	remoteKeys *remoteKeySet
 
-	// Public key
-	pubKey *rsa.PublicKey
+	// Encodes the payload into the type of TypedMiddleware, in place of PayloadFunc
+	payloadEncoder func(data interface{}) (MapClaims, error)
+
+	// Checks the claims decoded into the type of TypedMiddleware
+	claimsValidator func(claims MapClaims) error
 
 	// Optionally return the token as a cookie
 	SendCookie bool
@@ -137,17 +266,126 @@ type GfJWTMiddleware struct {
 	// SendAuthorization allow return authorization header for every request
 	SendAuthorization bool
 
+	// Window before the expiry of an access token in which the middleware issues a new token,
+	// so that the session slides as long as it is used. The new token is returned in the
+	// SlidingTokenHeader response header, and in the cookie if SendCookie is set.
+	// Optional, tokens are not reissued if not set.
+	SlidingWindow time.Duration
+
+	// Maximum lifetime of a session that slides in SlidingWindow, from its login as recorded in
+	// "orig_iat", which reissued tokens keep. Tokens are not reissued to expire past it.
+	// Optional, defaults to RefreshTokenTimeout if set, else MaxRefresh if set, else a day.
+	SlidingMaxLifetime time.Duration
+
+	// Response header of the tokens reissued in SlidingWindow, in the form "TokenHeadName TOKEN".
+	// Cross-origin clients need it in Access-Control-Expose-Headers. Optional, defaults to "Authorization".
+	SlidingTokenHeader string
+
+	// Duration the token replaced in SlidingWindow stays valid, for the requests that are
+	// already on their way with it. Optional, defaults to 30 seconds.
+	SlidingGracePeriod time.Duration
+
 	// Disable abort() of context.
 	DisabledAbort bool
 
+	// SetRequestError sets failures as the error of the request with r.SetError, along with the HTTP
+	// status, instead of responding with Unauthorized. The error is a gerror with the gcode code of the
+	// failure, whose gerror.Cause is the *Error of the failure. The response is written in the envelope
+	// of ghttp.MiddlewareHandlerResponse, {"code": GCODE, "message": MESSAGE, "data": null}, which
+	// the middleware leaves as it is, as it does not render the error of the request itself.
+	SetRequestError bool
+
 	// CookieName allow cookie name change for development
 	CookieName string
 
-	// CacheAdapter
+	// Store of revoked tokens. Optional, defaults to a store backed by CacheAdapter, or to a
+	// store in memory if CacheAdapter is not set. RefreshTokenRotation and SlidingWindow require
+	// a store whose SetIfNotExist is atomic, such as NewRedisRevocationStore for redis.
+	RevocationStore RevocationStore
+
+	// CacheAdapter of the default RevocationStore. Adapters other than the memory one, such as
+	// redis, cannot be used with RefreshTokenRotation or SlidingWindow: set RevocationStore instead.
 	CacheAdapter gcache.Adapter
 
 	// BlacklistPrefix
 	BlacklistPrefix string
+
+	// Behaviour when the RevocationStore fails to tell whether a token has been revoked.
+	// RevocationFailClosed rejects the request with 503, RevocationFailOpen accepts the token
+	// and logs a warning, at most once a minute. Optional, defaults to RevocationFailClosed.
+	RevocationFailurePolicy RevocationFailurePolicy
+
+	// Callback function that is called on every failure of the RevocationStore, e.g. to count them in a metric.
+	RevocationFailureHandler func(ctx context.Context, err error)
+
+	// Number of consecutive failures of the RevocationStore after which it is not called
+	// for RevocationBreakerCooldown, failing at once instead. Optional, defaults to 5.
+	RevocationBreakerThreshold int
+
+	// Duration the RevocationStore is not called after RevocationBreakerThreshold failures,
+	// after which a single request probes it. Optional, defaults to 30 seconds.
+	RevocationBreakerCooldown time.Duration
+
+	// Allow-list of sessions. If set, every login is registered in the TokenStore, and tokens
+	// are only accepted while their session is stored. Optional, tokens are not tracked if not set.
+	TokenStore TokenStore
+
+	// Callback function that returns the name of the device a login comes from, which is stored
+	// with its session. The context carries no request for tokens of TokenGenerator.
+	// Optional, by default no device is stored.
+	SessionDeviceFunc func(ctx context.Context) string
+
+	// Maximum number of sessions an identity can hold at once. Requires TokenStore. The logins of
+	// an identity are serialized within the process only: logins of an identity at the same time
+	// on several processes may exceed MaxSessions together.
+	// Optional, the number of sessions is not limited if not set.
+	MaxSessions int
+
+	// Minimum interval between two writes of the last activity, IP address and user agent of a session
+	// to the TokenStore, or of the activity of IdleTimeout to the RevocationStore, so that not every
+	// request writes to it. A session may thus time out up to SessionActivityInterval before IdleTimeout.
+	// Optional, defaults to one minute, or to a tenth of IdleTimeout if that is shorter.
+	SessionActivityInterval time.Duration
+
+	// Behaviour of a login beyond MaxSessions. SessionLimitReject rejects the login with
+	// ErrTooManySessions, SessionLimitEvictOldest signs out the oldest sessions of the identity.
+	// Optional, defaults to SessionLimitReject.
+	SessionLimitPolicy SessionLimitPolicy
+
+	// Duration after which a session that has not been used is rejected, within the lifetime
+	// of its tokens. Refresh tokens are rejected as well, as every token of a login carries the
+	// session ID. The last activity is that of the session in the TokenStore, or else is kept in
+	// the RevocationStore. Idle sessions can still be logged out.
+	// Optional, sessions do not time out on inactivity if not set.
+	IdleTimeout time.Duration
+
+	// Callback function that is called before a token is looked for. Requests for which it returns
+	// true pass through without authentication, e.g. health checks or OPTIONS preflight requests.
+	Skipper func(r *ghttp.Request) bool
+
+	// Patterns in gf router syntax of the requests to authenticate, such as "/api/*", optionally
+	// preceded by methods as in "GET,POST:/api/*". Optional, all requests are authenticated if not set.
+	IncludePaths []string
+
+	// Patterns in gf router syntax of the requests that pass through without authentication,
+	// such as "/api/public/*" or "OPTIONS:/*". ExcludePaths take precedence over IncludePaths.
+	ExcludePaths []string
+
+	// includeRules and excludeRules are the compiled IncludePaths and ExcludePaths
+	includeRules []pathRule
+	excludeRules []pathRule
+
+	// routeMetas caches the service routes of each server with their g.Meta tags
+	routeMetas sync.Map
+
+	// revocationStore is RevocationStore behind the circuit breaker
+	revocationStore RevocationStore
+
+	// failOpenLog limits the warnings about tokens accepted without revocation check
+	failOpenLog rateLimitedLog
+
+	// sessionLocks serializes the logins of each identity while MaxSessions is enforced
+	sessionLocks *gmlock.Locker
 }
 
 var (
@@ -157,8 +395,10 @@ var (
 	PayloadKey = "JWT_PAYLOAD"
 	// IdentityKey default identity key
 	IdentityKey = "identity"
-	// The blacklist stores tokens that have not expired but have been deactivated.
-	blacklist = gcache.New()
+	// RolesParamKey default jwt roles key in params
+	RolesParamKey = "JWT_ROLES"
+	// ScopesParamKey default jwt scopes key in params
+	ScopesParamKey = "JWT_SCOPES"
 )
 
 // New for check error with GfJWTMiddleware
@@ -171,6 +411,10 @@ func New(mw *GfJWTMiddleware) *GfJWTMiddleware {
 		mw.SigningAlgorithm = "HS256"
 	}
 
+	if jwt.GetSigningMethod(mw.SigningAlgorithm) == nil {
+		panic(ErrInvalidSigningAlgorithm)
+	}
+
 	if mw.Timeout == 0 {
 		mw.Timeout = time.Hour
 	}
@@ -193,6 +437,12 @@ func New(mw *GfJWTMiddleware) *GfJWTMiddleware {
 	if mw.Unauthorized == nil {
 		mw.Unauthorized = func(ctx context.Context, code int, message string) {
 			r := g.RequestFromCtx(ctx)
+			if mw.ProblemDetails == ProblemDetailsAlways ||
+				mw.ProblemDetails == ProblemDetailsAccept && acceptsProblem(r.Header.Get("Accept")) {
+				mw.ProblemUnauthorized(ctx, code, message)
+				return
+			}
+			r.Response.WriteHeader(code)
 			r.Response.WriteJson(g.Map{
 				"code":    code,
 				"message": message,
@@ -204,6 +454,14 @@ func New(mw *GfJWTMiddleware) *GfJWTMiddleware {
 		mw.IdentityKey = IdentityKey
 	}
 
+	if mw.RolesKey == "" {
+		mw.RolesKey = "roles"
+	}
+
+	if mw.ScopesKey == "" {
+		mw.ScopesKey = "scope"
+	}
+
 	if mw.IdentityHandler == nil {
 		mw.IdentityHandler = func(ctx context.Context) interface{} {
 			claims := ExtractClaims(ctx)
@@ -229,32 +487,140 @@ func New(mw *GfJWTMiddleware) *GfJWTMiddleware {
 		mw.CookieName = "jwt"
 	}
 
-	// bypass other key settings if KeyFunc is set
-	if mw.KeyFunc != nil {
-		return nil
+	if mw.JWKSMaxAge == 0 {
+		mw.JWKSMaxAge = time.Hour
 	}
 
-	if mw.usingPublicKeyAlgo() {
-		if err := mw.readKeys(); err != nil {
-			panic(err)
+	if mw.RefreshTokenLookup == "" {
+		mw.RefreshTokenLookup = "query:refresh_token"
+	}
+
+	if mw.RefreshSigningAlgorithm == "" {
+		mw.RefreshSigningAlgorithm = "HS256"
+	}
+
+	if len(mw.RefreshKey) > 0 {
+		switch mw.RefreshSigningAlgorithm {
+		case "HS256", "HS384", "HS512":
+		default:
+			panic(ErrInvalidSigningAlgorithm)
 		}
 	}
 
-	if mw.Key == nil {
-		panic(ErrMissingSecretKey)
+	// bypass other key settings if KeyFunc is set
+	if mw.KeyFunc == nil {
+		mw.setupKeys()
 	}
 
-	if mw.CacheAdapter != nil {
-		blacklist.SetAdapter(mw.CacheAdapter)
+	// The revocation store keeps tokens that have not expired but have been deactivated.
+	if mw.RevocationStore == nil {
+		if mw.CacheAdapter != nil {
+			mw.RevocationStore = NewCacheRevocationStore(mw.CacheAdapter)
+		} else {
+			mw.RevocationStore = NewMemoryRevocationStore()
+		}
 	}
 
 	if mw.BlacklistPrefix == "" {
 		mw.BlacklistPrefix = "JWT:BLACKLIST:"
 	}
 
+	if mw.RevocationBreakerThreshold <= 0 {
+		mw.RevocationBreakerThreshold = 5
+	}
+
+	if mw.RevocationBreakerCooldown <= 0 {
+		mw.RevocationBreakerCooldown = 30 * time.Second
+	}
+
+	// concurrent rotations and reissues are told apart by SetIfNotExist
+	if (mw.RefreshTokenRotation || mw.SlidingWindow > 0) && !atomicRevocationStore(mw.RevocationStore) {
+		panic(ErrNonAtomicRevocationStore)
+	}
+
+	mw.revocationStore = newBreakerRevocationStore(mw)
+
+	if mw.SlidingWindow > 0 {
+		if mw.SlidingTokenHeader == "" {
+			mw.SlidingTokenHeader = "Authorization"
+		}
+		if mw.SlidingGracePeriod <= 0 {
+			mw.SlidingGracePeriod = 30 * time.Second
+		}
+		if mw.SlidingMaxLifetime <= 0 {
+			switch {
+			case mw.RefreshTokenTimeout > 0:
+				mw.SlidingMaxLifetime = mw.RefreshTokenTimeout
+			case mw.MaxRefresh > 0:
+				mw.SlidingMaxLifetime = mw.MaxRefresh
+			default:
+				mw.SlidingMaxLifetime = 24 * time.Hour
+			}
+		}
+	}
+
+	var err error
+	if mw.includeRules, err = compilePathRules(mw.IncludePaths); err != nil {
+		panic(err)
+	}
+	if mw.excludeRules, err = compilePathRules(mw.ExcludePaths); err != nil {
+		panic(err)
+	}
+
+	if mw.SessionActivityInterval <= 0 {
+		mw.SessionActivityInterval = time.Minute
+		if mw.IdleTimeout > 0 && mw.IdleTimeout/10 < mw.SessionActivityInterval {
+			mw.SessionActivityInterval = mw.IdleTimeout / 10
+		}
+	}
+
+	if mw.MaxSessions > 0 && mw.TokenStore == nil {
+		panic(ErrMissingTokenStore)
+	}
+	if store, ok := mw.TokenStore.(*dbTokenStore); ok {
+		store.timeFunc = mw.TimeFunc
+	}
+	mw.sessionLocks = gmlock.New()
+
 	return mw
 }
 
+// setupKeys reads the key pair, the keyring and the remote key set settings.
+func (mw *GfJWTMiddleware) setupKeys() {
+	if len(mw.Keys) > 0 {
+		if err := mw.readKeyring(); err != nil {
+			panic(err)
+		}
+	}
+
+	if mw.JWKSURL != "" {
+		if mw.JWKSCacheTTL == 0 {
+			mw.JWKSCacheTTL = time.Hour
+		}
+		if mw.JWKSRefetchInterval == 0 {
+			mw.JWKSRefetchInterval = time.Minute
+		}
+		if mw.JWKSFetchTimeout <= 0 {
+			mw.JWKSFetchTimeout = 10 * time.Second
+		}
+		if mw.JWKSClient == nil {
+			mw.JWKSClient = newJWKSClient(mw.JWKSFetchTimeout)
+		}
+		mw.remoteKeys = newRemoteKeySet(mw)
+	}
+
+	optional := len(mw.Keys) > 0 || mw.JWKSURL != ""
+	if mw.usingPublicKeyAlgo() {
+		if !optional || mw.hasKeyFiles() {
+			if err := mw.readKeys(); err != nil {
+				panic(err)
+			}
+		}
+	} else if mw.Key == nil && !optional {
+		panic(ErrMissingSecretKey)
+	}
+}
+
 // MiddlewareFunc makes GfJWTMiddleware implement the Middleware interface.
 func (mw *GfJWTMiddleware) MiddlewareFunc() ghttp.HandlerFunc {
 	return func(r *ghttp.Request) {
@@ -262,6 +628,18 @@ func (mw *GfJWTMiddleware) MiddlewareFunc() ghttp.HandlerFunc {
 	}
 }
 
+// OptionalMiddlewareFunc is MiddlewareFunc for endpoints that also serve anonymous requests.
+// Requests without a token pass through without claims, while requests with a token that is
+// invalid or revoked are rejected as by MiddlewareFunc.
+func (mw *GfJWTMiddleware) OptionalMiddlewareFunc() ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		if _, err := mw.tokenFromRequest(r, mw.TokenLookup); isMissingToken(err) {
+			return
+		}
+		mw.middlewareImpl(r.GetCtx())
+	}
+}
+
 // GetClaimsFromJWT get claims from JWT token
 func (mw *GfJWTMiddleware) GetClaimsFromJWT(ctx context.Context) (MapClaims, string, error) {
 	r := g.RequestFromCtx(ctx)
@@ -272,9 +650,9 @@ func (mw *GfJWTMiddleware) GetClaimsFromJWT(ctx context.Context) (MapClaims, str
 	}
 
 	if mw.SendAuthorization {
-		token := r.Get(TokenKey).String()
+		token := r.GetParam(TokenKey).String()
 		if len(token) > 0 {
-			r.Header.Set("Authorization", mw.TokenHeadName+" "+token)
+			r.Response.Header().Set("Authorization", mw.TokenHeadName+" "+token)
 		}
 	}
 
@@ -289,52 +667,84 @@ func (mw *GfJWTMiddleware) GetClaimsFromJWT(ctx context.Context) (MapClaims, str
 // LoginHandler can be used by clients to get a jwt token.
 // Payload needs to be json in the form of {"username": "USERNAME", "password": "PASSWORD"}.
 // Reply will be of the form {"token": "TOKEN"}.
+// With RefreshTokenTimeout, it fails with ErrLoginPairRequired: use LoginPairHandler instead,
+// as the refresh token can not be returned.
 func (mw *GfJWTMiddleware) LoginHandler(ctx context.Context) (tokenString string, expire time.Time) {
+	if mw.usingRefreshTokens() {
+		mw.unauthorized(ctx, http.StatusInternalServerError, ErrLoginPairRequired)
+		return
+	}
+
+	_, tokenString, expire, _ = mw.login(ctx)
+	return
+}
+
+// login authenticates the user and issues an access token. On failure, the response is written by
+// unauthorized and ok is false.
+func (mw *GfJWTMiddleware) login(ctx context.Context) (claims jwt.MapClaims, tokenString string, expire time.Time, ok bool) {
 	if mw.Authenticator == nil {
-		mw.unauthorized(ctx, http.StatusInternalServerError, mw.HTTPStatusMessageFunc(ErrMissingAuthenticatorFunc, ctx))
+		mw.unauthorized(ctx, http.StatusInternalServerError, ErrMissingAuthenticatorFunc)
 		return
 	}
 
 	data, err := mw.Authenticator(ctx)
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, ctx))
+		mw.unauthorized(ctx, http.StatusUnauthorized, err)
 		return
 	}
 
 	r := g.RequestFromCtx(ctx)
-	// Create the token
-	token := jwt.New(jwt.GetSigningMethod(mw.SigningAlgorithm))
-	claims := token.Claims.(jwt.MapClaims)
+	claims, err = mw.payload(data)
+	if err != nil {
+		mw.unauthorized(ctx, http.StatusUnauthorized, ErrFailedTokenCreation)
+		return
+	}
 
-	if mw.PayloadFunc != nil {
-		for key, value := range mw.PayloadFunc(data) {
-			claims[key] = value
-		}
+	if _, exists := claims[mw.IdentityKey]; !exists {
+		mw.unauthorized(ctx, http.StatusInternalServerError, ErrMissingIdentity)
+		return
+	}
+
+	if mw.MaxSessions > 0 {
+		unlock := mw.lockSessions(claims[mw.IdentityKey])
+		defer unlock()
 	}
 
-	if _, ok := claims[mw.IdentityKey]; !ok {
-		mw.unauthorized(ctx, http.StatusInternalServerError, mw.HTTPStatusMessageFunc(ErrMissingIdentity, ctx))
+	if err = mw.checkSessionLimit(ctx, claims[mw.IdentityKey]); err != nil {
+		mw.unauthorized(ctx, sessionStatus(err), err)
 		return
 	}
 
-	expire = mw.TimeFunc().Add(mw.Timeout)
-	claims["exp"] = expire.UnixNano() / 1e6
-	claims["orig_iat"] = mw.TimeFunc().UnixNano() / 1e6
+	if mw.usingRefreshTokens() && mw.RefreshTokenRotation {
+		claims[familyClaim] = guid.S()
+	}
+
+	mw.startSession(claims)
 
-	tokenString, err = mw.signedString(token)
+	tokenString, expire, err = mw.newAccessToken(claims)
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(ErrFailedTokenCreation, ctx))
+		mw.unauthorized(ctx, http.StatusUnauthorized, ErrFailedTokenCreation)
+		return
+	}
+
+	if err = mw.registerSession(ctx, claims, tokenString, expire); err != nil {
+		mw.unauthorized(ctx, revocationStatus(err), err)
+		return
+	}
+
+	if err = mw.evictSessions(ctx, claims); err != nil {
+		// the login is undone rather than leaving the identity beyond MaxSessions
+		_ = mw.endSession(ctx, claims)
+		mw.unauthorized(ctx, sessionStatus(err), err)
 		return
 	}
 
 	// set cookie
 	if mw.SendCookie {
-		expireCookie := mw.TimeFunc().Add(mw.CookieMaxAge)
-		maxAge := (expireCookie.UnixNano() - mw.TimeFunc().UnixNano()) / 1e6
-		r.Cookie.SetCookie(mw.CookieName, tokenString, mw.CookieDomain, "/", time.Duration(maxAge)*time.Second)
+		r.Cookie.SetCookie(mw.CookieName, tokenString, mw.CookieDomain, "/", mw.CookieMaxAge)
 	}
 
-	return
+	return claims, tokenString, expire, true
 }
 
 // LogoutHandler can be used by clients to remove the jwt cookie (if set)
@@ -346,64 +756,97 @@ func (mw *GfJWTMiddleware) LogoutHandler(ctx context.Context) {
 		r.Cookie.SetCookie(mw.CookieName, "", mw.CookieDomain, "/", -1)
 	}
 
-	claims, token, err := mw.CheckIfTokenExpire(ctx)
+	// idle sessions are logged out as well
+	claims, token, err := mw.checkIfTokenExpire(ctx, false)
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, ctx))
+		mw.unauthorized(ctx, revocationStatus(err), err)
 		return
 	}
 
 	err = mw.setBlacklist(ctx, token, claims)
 
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, ctx))
+		mw.unauthorized(ctx, revocationStatus(err), err)
 		return
 	}
 
+	if err = mw.endSession(ctx, claims); err != nil {
+		mw.unauthorized(ctx, revocationStatus(err), err)
+		return
+	}
+
+	// revoke the refresh tokens of the login too
+	if family, ok := claims[familyClaim].(string); ok && mw.RefreshTokenRotation {
+		if err = mw.setFamilyBlacklist(ctx, family); err != nil {
+			mw.unauthorized(ctx, revocationStatus(err), err)
+			return
+		}
+	}
+
+	// revoke the refresh token too, if the client sent it
+	if mw.usingRefreshTokens() {
+		if refreshToken, err := mw.parseRefreshToken(r); err == nil {
+			_ = mw.setBlacklist(ctx, refreshToken.Raw, refreshToken.Claims.(jwt.MapClaims))
+		}
+	}
+
 	return
 }
 
 // RefreshHandler can be used to refresh a token. The token still needs to be valid on refresh.
+// With RefreshTokenRotation, it fails with ErrRefreshPairRequired: use RefreshPairHandler instead,
+// as the rotated refresh token can not be returned.
 // Shall be put under an endpoint that is using the GfJWTMiddleware.
 // Reply will be of the form {"token": "TOKEN"}.
 func (mw *GfJWTMiddleware) RefreshHandler(ctx context.Context) (tokenString string, expire time.Time) {
 	tokenString, expire, err := mw.RefreshToken(ctx)
+	if errors.Is(err, ErrRefreshPairRequired) {
+		mw.unauthorized(ctx, http.StatusInternalServerError, err)
+		return
+	}
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, ctx))
+		mw.unauthorized(ctx, revocationStatus(err), err)
 		return
 	}
 
 	return
 }
 
-// RefreshToken refresh token and check if token is expired
+// RefreshToken refresh token and check if token is expired.
+// If RefreshTokenTimeout is set, a new access token is issued for the refresh token of the request.
+// With RefreshTokenRotation, it returns ErrRefreshPairRequired without rotating the refresh token,
+// as the caller could not receive the rotated one.
 func (mw *GfJWTMiddleware) RefreshToken(ctx context.Context) (string, time.Time, error) {
+	if mw.usingRefreshTokens() && mw.RefreshTokenRotation {
+		return "", time.Now(), ErrRefreshPairRequired
+	}
+
+	if mw.usingRefreshTokens() {
+		pair, err := mw.refreshTokenPair(ctx)
+		if err != nil {
+			return "", time.Now(), err
+		}
+		return pair.Token, pair.Expire, nil
+	}
+
 	claims, token, err := mw.CheckIfTokenExpire(ctx)
 	if err != nil {
 		return "", time.Now(), err
 	}
 
 	r := g.RequestFromCtx(ctx)
-	// Create the token
-	newToken := jwt.New(jwt.GetSigningMethod(mw.SigningAlgorithm))
-	newClaims := newToken.Claims.(jwt.MapClaims)
-
-	for key := range claims {
-		newClaims[key] = claims[key]
+	tokenString, expire, err := mw.newAccessToken(claims)
+	if err != nil {
+		return "", time.Now(), err
 	}
 
-	expire := mw.TimeFunc().Add(mw.Timeout)
-	newClaims["exp"] = expire.UnixNano() / 1e6
-	newClaims["orig_iat"] = mw.TimeFunc().UnixNano() / 1e6
-	tokenString, err := mw.signedString(newToken)
-	if err != nil {
+	if err = mw.renewSession(ctx, claims, tokenString, mw.sessionExpiry(expire)); err != nil {
 		return "", time.Now(), err
 	}
 
 	// set cookie
 	if mw.SendCookie {
-		expireCookie := mw.TimeFunc().Add(mw.CookieMaxAge)
-		maxAge := (expireCookie.UnixNano() - time.Now().UnixNano()) / 1e6
-		r.Cookie.SetCookie(mw.CookieName, tokenString, mw.CookieDomain, "/", time.Duration(maxAge)*time.Second)
+		r.Cookie.SetCookie(mw.CookieName, tokenString, mw.CookieDomain, "/", mw.CookieMaxAge)
 	}
 
 	// set old token in blacklist
@@ -417,6 +860,11 @@ func (mw *GfJWTMiddleware) RefreshToken(ctx context.Context) (string, time.Time,
 
 // CheckIfTokenExpire check if token expire
 func (mw *GfJWTMiddleware) CheckIfTokenExpire(ctx context.Context) (jwt.MapClaims, string, error) {
+	return mw.checkIfTokenExpire(ctx, true)
+}
+
+// checkIfTokenExpire is CheckIfTokenExpire, which rejects idle sessions only if checkIdle is set.
+func (mw *GfJWTMiddleware) checkIfTokenExpire(ctx context.Context, checkIdle bool) (jwt.MapClaims, string, error) {
 	r := g.RequestFromCtx(ctx)
 
 	token, err := mw.parseToken(r)
@@ -432,19 +880,33 @@ func (mw *GfJWTMiddleware) CheckIfTokenExpire(ctx context.Context) (jwt.MapClaim
 		}
 	}
 
-	in, err := mw.inBlacklist(ctx, token.Raw)
-	if err != nil {
+	claims := token.Claims.(jwt.MapClaims)
+
+	if err = mw.checkRevocation(ctx, token.Raw, MapClaims(claims)); err != nil {
 		return nil, "", err
 	}
-	if in {
-		return nil, "", ErrInvalidToken
+
+	if err = mw.validateClaims(MapClaims(claims), accessTokenType); err != nil {
+		return nil, "", err
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
+	session, err := mw.lookupSession(ctx, MapClaims(claims), accessTokenType)
+	if err != nil {
+		return nil, "", err
+	}
 
-	exp := int64(claims["exp"].(float64))
+	if checkIdle {
+		if err = mw.checkIdle(ctx, MapClaims(claims), session); err != nil {
+			return nil, "", err
+		}
+	}
+
+	exp, ok := mw.parseTimestamp(claims["exp"])
+	if !ok {
+		return nil, "", ErrWrongFormatOfExp
+	}
 
-	if exp < (mw.TimeFunc().Add(-mw.MaxRefresh).UnixNano() / 1e6) {
+	if exp.Before(mw.TimeFunc().Add(-mw.MaxRefresh).Add(-mw.Leeway)) {
 		return nil, "", ErrExpiredToken
 	}
 
@@ -453,57 +915,61 @@ func (mw *GfJWTMiddleware) CheckIfTokenExpire(ctx context.Context) (jwt.MapClaim
 
 // TokenGenerator method that clients can use to get a jwt token.
 func (mw *GfJWTMiddleware) TokenGenerator(data interface{}) (string, time.Time, error) {
-	token := jwt.New(jwt.GetSigningMethod(mw.SigningAlgorithm))
-	claims := token.Claims.(jwt.MapClaims)
-
-	if mw.PayloadFunc != nil {
-		for key, value := range mw.PayloadFunc(data) {
-			claims[key] = value
-		}
+	claims, err := mw.payload(data)
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
-	expire := mw.TimeFunc().UTC().Add(mw.Timeout)
-	claims["exp"] = expire.UnixNano() / 1e6
-	claims["orig_iat"] = mw.TimeFunc().UnixNano() / 1e6
-	tokenString, err := mw.signedString(token)
+	mw.startSession(claims)
+
+	tokenString, expire, err := mw.newAccessToken(claims)
 	if err != nil {
 		return "", time.Time{}, err
 	}
 
-	return tokenString, expire, nil
+	if err = mw.registerSession(context.Background(), claims, tokenString, expire); err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expire.UTC(), nil
 }
 
-// GetToken help to get the JWT token string
+// GetToken help to get the JWT token string. It is read from the params set by the middleware
+// only, so that requests passing through without authentication can not make one up.
 func (mw *GfJWTMiddleware) GetToken(ctx context.Context) string {
 	r := g.RequestFromCtx(ctx)
-	token := r.Get(TokenKey).String()
+	token := r.GetParam(TokenKey).String()
 	if len(token) == 0 {
 		return ""
 	}
 	return token
 }
 
-// GetPayload help to get the payload map
+// GetPayload help to get the payload map, from the params set by the middleware only.
 func (mw *GfJWTMiddleware) GetPayload(ctx context.Context) string {
 	r := g.RequestFromCtx(ctx)
-	token := r.Get(PayloadKey).String()
+	token := r.GetParam(PayloadKey).String()
 	if len(token) == 0 {
 		return ""
 	}
 	return token
 }
 
-// GetIdentity help to get the identity
+// GetIdentity help to get the identity, from the params set by the middleware only: the query,
+// form and body of requests passing through without authentication are not read.
 func (mw *GfJWTMiddleware) GetIdentity(ctx context.Context) interface{} {
 	r := g.RequestFromCtx(ctx)
-	return r.Get(mw.IdentityKey)
+	return r.GetParam(mw.IdentityKey)
 }
 
-// ExtractClaims help to extract the JWT claims
+// ExtractClaims help to extract the JWT claims, empty if the request carries no claims
 func ExtractClaims(ctx context.Context) MapClaims {
 	r := g.RequestFromCtx(ctx)
-	claims := r.GetParam(PayloadKey).Interface()
-	return claims.(MapClaims)
+	claims, ok := r.GetParam(PayloadKey).Interface().(MapClaims)
+	if !ok {
+		return make(MapClaims)
+	}
+	return claims
 }
 
 // ExtractClaimsFromToken help to extract the JWT claims from token
@@ -521,6 +987,55 @@ func ExtractClaimsFromToken(token *jwt.Token) MapClaims {
 }
 
 // ================= private func =================
+// payload returns the claims that PayloadFunc, or the payload encoder of TypedMiddleware, adds for data.
+func (mw *GfJWTMiddleware) payload(data interface{}) (jwt.MapClaims, error) {
+	var (
+		payload MapClaims
+		err     error
+	)
+	switch {
+	case mw.payloadEncoder != nil:
+		if payload, err = mw.payloadEncoder(data); err != nil {
+			return nil, err
+		}
+	case mw.PayloadFunc != nil:
+		payload = mw.PayloadFunc(data)
+	}
+
+	claims := jwt.MapClaims{}
+	for key, value := range payload {
+		claims[key] = value
+	}
+	return claims, nil
+}
+
+// newAccessToken signs an access token carrying claims, valid for Timeout.
+func (mw *GfJWTMiddleware) newAccessToken(claims jwt.MapClaims) (string, time.Time, error) {
+	now := mw.TimeFunc()
+	expire := now.Add(mw.Timeout)
+	tokenString, err := mw.signAccessToken(claims, now, expire)
+	return tokenString, expire, err
+}
+
+// signAccessToken signs an access token carrying claims, which expires at expire and whose
+// "orig_iat" is origIat.
+func (mw *GfJWTMiddleware) signAccessToken(claims jwt.MapClaims, origIat, expire time.Time) (string, error) {
+	token := jwt.New(jwt.GetSigningMethod(mw.SigningAlgorithm))
+	newClaims := token.Claims.(jwt.MapClaims)
+
+	for key, value := range claims {
+		if key != tokenTypeClaim {
+			newClaims[key] = value
+		}
+	}
+
+	newClaims["exp"] = mw.timestamp(expire)
+	newClaims["orig_iat"] = mw.timestamp(origIat)
+	mw.setRegisteredClaims(newClaims, mw.TimeFunc())
+
+	return mw.signedString(token)
+}
+
 func (mw *GfJWTMiddleware) readKeys() error {
 	err := mw.privateKey()
 	if err != nil {
@@ -534,29 +1049,16 @@ func (mw *GfJWTMiddleware) readKeys() error {
 }
 
 func (mw *GfJWTMiddleware) privateKey() error {
-	var keyData []byte
-	if mw.PrivKeyFile == "" {
-		keyData = mw.PrivKeyBytes
-	} else {
-		fileContent, err := ioutil.ReadFile(mw.PrivKeyFile)
-		if err != nil {
-			return ErrNoPrivKeyFile
-		}
-		keyData = fileContent
-	}
-
-	if mw.PrivateKeyPassphrase != "" {
-		//nolint:static check
-		key, err := jwt.ParseRSAPrivateKeyFromPEMWithPassword(keyData, mw.PrivateKeyPassphrase)
-		if err != nil {
-			return ErrInvalidPrivKey
-		}
-		mw.privKey = key
-		return nil
+	keyData, err := readKeyData(mw.PrivKeyFile, mw.PrivKeyBytes, ErrNoPrivKeyFile)
+	if err != nil {
+		return err
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	key, err := parsePrivateKey(keyData, mw.PrivateKeyPassphrase)
 	if err != nil {
+		return err
+	}
+	if !keyMatchesAlgorithm(mw.SigningAlgorithm, key) {
 		return ErrInvalidPrivKey
 	}
 	mw.privKey = key
@@ -564,19 +1066,22 @@ func (mw *GfJWTMiddleware) privateKey() error {
 }
 
 func (mw *GfJWTMiddleware) publicKey() error {
-	var keyData []byte
-	if mw.PubKeyFile == "" {
-		keyData = mw.PubKeyBytes
-	} else {
-		fileContent, err := ioutil.ReadFile(mw.PubKeyFile)
-		if err != nil {
-			return ErrNoPubKeyFile
-		}
-		keyData = fileContent
+	if mw.PubKeyFile == "" && len(mw.PubKeyBytes) == 0 {
+		// derive the public key from the private key
+		mw.pubKey = publicOf(mw.privKey)
+		return nil
 	}
 
-	key, err := jwt.ParseRSAPublicKeyFromPEM(keyData)
+	keyData, err := readKeyData(mw.PubKeyFile, mw.PubKeyBytes, ErrNoPubKeyFile)
 	if err != nil {
+		return err
+	}
+
+	key, err := parsePublicKey(keyData)
+	if err != nil {
+		return err
+	}
+	if !keyMatchesAlgorithm(mw.SigningAlgorithm, key) {
 		return ErrInvalidPubKey
 	}
 	mw.pubKey = key
@@ -584,14 +1089,29 @@ func (mw *GfJWTMiddleware) publicKey() error {
 }
 
 func (mw *GfJWTMiddleware) usingPublicKeyAlgo() bool {
-	switch mw.SigningAlgorithm {
-	case "RS256", "RS512", "RS384":
-		return true
-	}
-	return false
+	return isPublicKeyAlgo(mw.SigningAlgorithm)
+}
+
+func (mw *GfJWTMiddleware) hasKeyFiles() bool {
+	return mw.PrivKeyFile != "" || len(mw.PrivKeyBytes) > 0 || mw.PubKeyFile != "" || len(mw.PubKeyBytes) > 0
 }
 
 func (mw *GfJWTMiddleware) signedString(token *jwt.Token) (string, error) {
+	if key := mw.activeKey(); key != nil {
+		token.Method = jwt.GetSigningMethod(key.Algorithm)
+		token.Header["alg"] = key.Algorithm
+		token.Header["kid"] = key.ID
+		return token.SignedString(key.signKey())
+	}
+
+	if !mw.hasLegacyKey() {
+		return "", ErrNoActiveSigningKey
+	}
+
+	if mw.KeyID != "" {
+		token.Header["kid"] = mw.KeyID
+	}
+
 	var tokenString string
 	var err error
 	if mw.usingPublicKeyAlgo() {
@@ -602,6 +1122,14 @@ func (mw *GfJWTMiddleware) signedString(token *jwt.Token) (string, error) {
 	return tokenString, err
 }
 
+// isMissingToken reports whether err is returned for a request carrying no token at all.
+func isMissingToken(err error) bool {
+	return errors.Is(err, ErrEmptyAuthHeader) ||
+		errors.Is(err, ErrEmptyQueryToken) ||
+		errors.Is(err, ErrEmptyCookieToken) ||
+		errors.Is(err, ErrEmptyParamToken)
+}
+
 func (mw *GfJWTMiddleware) jwtFromHeader(r *ghttp.Request, key string) (string, error) {
 	authHeader := r.Header.Get(key)
 
@@ -648,10 +1176,34 @@ func (mw *GfJWTMiddleware) jwtFromParam(r *ghttp.Request, key string) (string, e
 }
 
 func (mw *GfJWTMiddleware) parseToken(r *ghttp.Request) (*jwt.Token, error) {
+	token, err := mw.tokenFromRequest(r, mw.TokenLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	if mw.KeyFunc != nil {
+		return mw.parser().Parse(token, mw.KeyFunc)
+	}
+
+	return mw.parser().Parse(token, func(t *jwt.Token) (interface{}, error) {
+		key, err := mw.verificationKey(r.Context(), t)
+		if err != nil {
+			return nil, err
+		}
+
+		// save token string if valid
+		r.SetParam(TokenKey, token)
+
+		return key, nil
+	})
+}
+
+// tokenFromRequest extracts the token string from the request with a TokenLookup string.
+func (mw *GfJWTMiddleware) tokenFromRequest(r *ghttp.Request, lookup string) (string, error) {
 	var token string
 	var err error
 
-	methods := strings.Split(mw.TokenLookup, ",")
+	methods := strings.Split(lookup, ",")
 	for _, method := range methods {
 		if len(token) > 0 {
 			break
@@ -672,49 +1224,89 @@ func (mw *GfJWTMiddleware) parseToken(r *ghttp.Request) (*jwt.Token, error) {
 	}
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
+	return token, nil
+}
+
+func (mw *GfJWTMiddleware) parseTokenString(ctx context.Context, token string) (*jwt.Token, error) {
 	if mw.KeyFunc != nil {
-		return jwt.Parse(token, mw.KeyFunc)
+		return mw.parser().Parse(token, mw.KeyFunc)
 	}
 
-	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
-			return nil, ErrInvalidSigningAlgorithm
-		}
-		if mw.usingPublicKeyAlgo() {
-			return mw.pubKey, nil
-		}
-
-		// save token string if valid
-		r.SetParam(TokenKey, token)
-
-		return mw.Key, nil
+	return mw.parser().Parse(token, func(t *jwt.Token) (interface{}, error) {
+		return mw.verificationKey(ctx, t)
 	})
 }
 
-func (mw *GfJWTMiddleware) parseTokenString(token string) (*jwt.Token, error) {
-	if mw.KeyFunc != nil {
-		return jwt.Parse(token, mw.KeyFunc)
+// parser returns the token parser. The registered claims are validated by the middleware
+// itself, so that Leeway is honoured.
+func (mw *GfJWTMiddleware) parser() *jwt.Parser {
+	return &jwt.Parser{SkipClaimsValidation: true}
+}
+
+func (mw *GfJWTMiddleware) hasLegacyKey() bool {
+	if mw.usingPublicKeyAlgo() {
+		return mw.pubKey != nil
 	}
+	return mw.Key != nil
+}
 
-	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
-			return nil, ErrInvalidSigningAlgorithm
+// verificationKey returns the key that verifies token t, selected by its "kid" header.
+func (mw *GfJWTMiddleware) verificationKey(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid != "" && kid != mw.KeyID && len(mw.Keys) > 0 {
+		if key := mw.lookupKey(kid); key != nil {
+			if !key.activeAt(mw.TimeFunc()) {
+				return nil, ErrUnknownKeyID
+			}
+			if jwt.GetSigningMethod(key.Algorithm) != t.Method {
+				return nil, ErrInvalidSigningAlgorithm
+			}
+			return key.verifyKey(), nil
 		}
-		if mw.usingPublicKeyAlgo() {
-			return mw.pubKey, nil
+		if mw.remoteKeys == nil {
+			return nil, ErrUnknownKeyID
 		}
+	}
 
-		return mw.Key, nil
-	})
+	if mw.remoteKeys != nil && (kid != mw.KeyID || !mw.hasLegacyKey()) {
+		return mw.remoteKeys.verificationKey(ctx, kid, t.Method.Alg())
+	}
+
+	if !mw.hasLegacyKey() {
+		return nil, ErrUnknownKeyID
+	}
+	if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
+		return nil, ErrInvalidSigningAlgorithm
+	}
+	if mw.usingPublicKeyAlgo() {
+		return mw.pubKey, nil
+	}
+
+	return mw.Key, nil
 }
 
-func (mw *GfJWTMiddleware) unauthorized(ctx context.Context, code int, message string) {
+// unauthorized responds to a request failing with err, along with the WWW-Authenticate challenge
+// of the error, or sets the error of the request with SetRequestError.
+func (mw *GfJWTMiddleware) unauthorized(ctx context.Context, code int, err error) {
 	r := g.RequestFromCtx(ctx)
-	r.Header.Set("WWW-Authenticate", "JWT realm="+mw.Realm)
-	mw.Unauthorized(ctx, code, message)
+	e := mw.newError(ctx, code, err)
+	if challenge := mw.challenge(e); challenge != "" {
+		r.Response.Header().Set("WWW-Authenticate", challenge)
+	}
+	if mw.SetRequestError {
+		// the code of e is kept, as gerror takes the code of the error it wraps when given none
+		r.Response.WriteHeader(code)
+		r.SetError(gerror.WrapCode(gcode.CodeNil, e))
+		r.Response.WriteJson(ghttp.DefaultHandlerResponse{
+			Code:    e.Code().Code(),
+			Message: e.Error(),
+		})
+	} else {
+		mw.Unauthorized(context.WithValue(ctx, errorCtxKey{}, e), code, e.Message)
+	}
 	if !mw.DisabledAbort {
 		r.ExitAll()
 	}
@@ -723,90 +1315,153 @@ func (mw *GfJWTMiddleware) unauthorized(ctx context.Context, code int, message s
 func (mw *GfJWTMiddleware) middlewareImpl(ctx context.Context) {
 	r := g.RequestFromCtx(ctx)
 
+	if mw.skip(r) {
+		return
+	}
+
+	route, err := mw.resolveRoute(r)
+	if err != nil {
+		g.Log().Errorf(ctx, "jwt: %v: %s %s", err, r.Method, r.URL.Path)
+		mw.unauthorized(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	var meta map[string]string
+	if route != nil {
+		meta = route.meta
+	}
+	switch meta[metaAuth] {
+	case authNone:
+		return
+	case authOptional:
+		if _, err := mw.tokenFromRequest(r, mw.TokenLookup); isMissingToken(err) {
+			return
+		}
+	}
+
 	claims, token, err := mw.GetClaimsFromJWT(ctx)
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, ctx))
+		mw.unauthorized(ctx, http.StatusUnauthorized, err)
 		return
 	}
 
 	if claims["exp"] == nil {
-		mw.unauthorized(ctx, http.StatusBadRequest, mw.HTTPStatusMessageFunc(ErrMissingExpField, ctx))
+		mw.unauthorized(ctx, http.StatusBadRequest, ErrMissingExpField)
 		return
 	}
 
-	if _, ok := claims["exp"].(float64); !ok {
-		mw.unauthorized(ctx, http.StatusBadRequest, mw.HTTPStatusMessageFunc(ErrWrongFormatOfExp, ctx))
+	exp, ok := mw.parseTimestamp(claims["exp"])
+	if !ok {
+		mw.unauthorized(ctx, http.StatusBadRequest, ErrWrongFormatOfExp)
 		return
 	}
 
-	if int64(claims["exp"].(float64)) < (mw.TimeFunc().UnixNano() / 1e6) {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(ErrExpiredToken, ctx))
+	if exp.Before(mw.TimeFunc().Add(-mw.Leeway)) {
+		mw.unauthorized(ctx, http.StatusUnauthorized, ErrExpiredToken)
 		return
 	}
 
-	in, err := mw.inBlacklist(ctx, token)
+	if err = mw.validateClaims(claims, accessTokenType); err != nil {
+		mw.unauthorized(ctx, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err = mw.checkRevocation(ctx, token, claims); err != nil {
+		mw.unauthorized(ctx, revocationStatus(err), err)
+		return
+	}
+
+	session, err := mw.lookupSession(ctx, claims, accessTokenType)
 	if err != nil {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, ctx))
+		mw.unauthorized(ctx, revocationStatus(err), err)
 		return
 	}
 
-	if in {
-		mw.unauthorized(ctx, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(ErrInvalidToken, ctx))
+	if err = mw.checkIdle(ctx, claims, session); err != nil {
+		mw.unauthorized(ctx, revocationStatus(err), err)
 		return
 	}
 
+	mw.touchSession(ctx, session)
+
 	r.SetParam(PayloadKey, claims)
 
+	roles, scopes := claimValues(claims[mw.RolesKey]), claimValues(claims[mw.ScopesKey])
+	r.SetParam(RolesParamKey, roles)
+	r.SetParam(ScopesParamKey, scopes)
+
 	identity := mw.IdentityHandler(ctx)
 	if identity != nil {
 		r.SetParam(mw.IdentityKey, identity)
 	}
 
+	if err = authorizeRoute(roles, scopes, meta); err != nil {
+		mw.unauthorized(ctx, http.StatusForbidden, err)
+		return
+	}
+
 	if !mw.Authorizator(identity, ctx) {
-		mw.unauthorized(ctx, http.StatusForbidden, mw.HTTPStatusMessageFunc(ErrForbidden, ctx))
+		mw.unauthorized(ctx, http.StatusForbidden, ErrForbidden)
+		return
+	}
+
+	policyRequest := &PolicyRequest{
+		Identity: identity,
+		Claims:   claims,
+		Roles:    roles,
+		Scopes:   scopes,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Meta:     meta,
+	}
+	if route != nil {
+		policyRequest.Route = route.router.Uri
+	}
+	if err = mw.enforcePolicy(ctx, policyRequest); err != nil {
+		mw.unauthorized(ctx, http.StatusForbidden, err)
 		return
 	}
 
+	// the request goes on with the current token if it can not be reissued
+	_ = mw.slide(ctx, token, claims, exp)
+
 	//c.Next() todo
 }
 
 func (mw *GfJWTMiddleware) setBlacklist(ctx context.Context, token string, claims jwt.MapClaims) error {
-	// The goal of MD5 is to reduce the key length.
-	token, err := gmd5.EncryptString(token)
+	return mw.setBlacklistAt(ctx, token, claims, mw.TimeFunc())
+}
 
+// setBlacklistAt revokes the token from the given time on, which may lie in the future.
+func (mw *GfJWTMiddleware) setBlacklistAt(ctx context.Context, token string, claims jwt.MapClaims, at time.Time) error {
+	key, err := mw.blacklistKey(token, MapClaims(claims))
 	if err != nil {
 		return err
 	}
 
-	exp := int64(claims["exp"].(float64))
-
-	// save duration time = (exp + max_refresh) - now
-	duration := time.Unix(exp, 0).Add(mw.MaxRefresh).Sub(mw.TimeFunc()).Truncate(time.Second)
+	exp, ok := mw.parseTimestamp(claims["exp"])
+	if !ok {
+		return ErrWrongFormatOfExp
+	}
 
-	key := mw.BlacklistPrefix + token
-	// global gcache
-	err = blacklist.Set(ctx, key, true, duration)
+	err = mw.revocationStore.Set(ctx, key, at.Unix(), mw.revocationDuration(exp))
 
 	if err != nil {
-		return err
+		return mw.revocationFailure(ctx, err)
 	}
 
 	return nil
 }
 
-func (mw *GfJWTMiddleware) inBlacklist(ctx context.Context, token string) (bool, error) {
-	// The goal of MD5 is to reduce the key length.
-	tokenRaw, err := gmd5.EncryptString(token)
-
+func (mw *GfJWTMiddleware) inBlacklist(ctx context.Context, token string, claims MapClaims) (bool, error) {
+	key, err := mw.blacklistKey(token, claims)
 	if err != nil {
-		return false, nil
+		return false, err
 	}
 
-	key := mw.BlacklistPrefix + tokenRaw
-	// Global gcache
-	if in, err := blacklist.Contains(ctx, key); err != nil {
-		return false, nil
-	} else {
-		return in, nil
+	// entries hold the time the revocation takes effect, or true if it took effect at once
+	v, err := mw.revocationStore.Get(ctx, key)
+	if err != nil || v == nil || v.IsNil() {
+		return false, err
 	}
+	return v.Int64() <= mw.TimeFunc().Unix(), nil
 }
@@ -0,0 +1,154 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/util/guid"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ctx = context.Background()
+
+// newTestMiddleware creates a HS256 middleware whose payload is the MapClaims given as data.
+func newTestMiddleware(mw *GfJWTMiddleware) *GfJWTMiddleware {
+	if mw.Key == nil && mw.JWKSURL == "" && len(mw.Keys) == 0 && mw.PrivKeyBytes == nil {
+		mw.Key = []byte("secret key")
+	}
+	if mw.PayloadFunc == nil {
+		mw.PayloadFunc = func(data interface{}) MapClaims {
+			return data.(MapClaims)
+		}
+	}
+	return New(mw)
+}
+
+// newTestServer starts a server with the routes bound by register, and returns a client of it.
+func newTestServer(t *testing.T, register func(s *ghttp.Server)) *gclient.Client {
+	s := g.Server(guid.S())
+	register(s)
+	s.SetDumpRouterMap(false)
+	s.SetAccessLogEnabled(false)
+	s.SetErrorLogEnabled(false)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Shutdown() })
+	time.Sleep(100 * time.Millisecond)
+
+	return g.Client().Prefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+}
+
+// authMiddleware wraps MiddlewareFunc, which does not call the next handler itself.
+func authMiddleware(mw *GfJWTMiddleware) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		mw.MiddlewareFunc()(r)
+		r.Middleware.Next()
+	}
+}
+
+// bearer returns a client sending token in the Authorization header.
+func bearer(c *gclient.Client, token string) *gclient.Client {
+	return c.Header(map[string]string{"Authorization": "Bearer " + token})
+}
+
+// unverifiedClaims returns the claims of token without verifying it.
+func unverifiedClaims(token string) MapClaims {
+	t, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil
+	}
+	return MapClaims(t.Claims.(jwt.MapClaims))
+}
+
+// newECKeyPEM generates a P-256 private key in PEM.
+func newECKeyPEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// testClock is a TimeFunc that can be moved forward.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Now()}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type anonymousReq struct {
+	g.Meta `method:"get" auth:"none"`
+}
+
+type anonymousRes struct{}
+
+func TestGetIdentity_Anonymous(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{ExcludePaths: []string{"/public/*"}})
+	whoami := func(r *ghttp.Request) {
+		r.Response.Writef("%v|%s|%s", mw.GetIdentity(r.Context()), mw.GetToken(r.Context()), mw.GetPayload(r.Context()))
+	}
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(func(r *ghttp.Request) {
+				mw.OptionalMiddlewareFunc()(r)
+				r.Middleware.Next()
+			})
+			group.ALL("/feed", whoami)
+		})
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/public/feed", whoami)
+			group.GET("/none", func(ctx context.Context, req *anonymousReq) (*anonymousRes, error) {
+				whoami(g.RequestFromCtx(ctx))
+				return nil, nil
+			})
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		spoof := "?identity=admin&" + TokenKey + "=token&" + PayloadKey + "=payload"
+
+		// requests passing through without authentication can not make their identity up
+		t.Assert(c.GetContent(ctx, "/feed"+spoof), "||")
+		t.Assert(c.PostContent(ctx, "/feed", g.Map{"identity": "admin"}), "||")
+		t.Assert(c.GetContent(ctx, "/public/feed"+spoof), "||")
+		t.Assert(c.GetContent(ctx, "/none"+spoof), "||")
+
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(strings.HasPrefix(bearer(c, token).GetContent(ctx, "/feed"+spoof), "a|"+token+"|"), true)
+	})
+}
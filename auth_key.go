@@ -0,0 +1,324 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a key of the GfJWTMiddleware keyring. Tokens signed with a keyring key carry its ID
+// in the "kid" header, so that keys can be rotated without invalidating outstanding tokens.
+type SigningKey struct {
+	// ID of the key, written to the "kid" header of signed tokens. Required.
+	ID string
+
+	// Signing algorithm of the key. Optional, defaults to SigningAlgorithm of the middleware.
+	Algorithm string
+
+	// Secret key for HMAC algorithms.
+	Key []byte
+
+	// Private key file for asymmetric algorithms.
+	// Optional, a key without private key is only used for verification.
+	PrivKeyFile string
+
+	// Private Key bytes for asymmetric algorithms
+	//
+	// Note: PrivKeyFile takes precedence over PrivKeyBytes if both are set
+	PrivKeyBytes []byte
+
+	// Private key passphrase
+	PrivateKeyPassphrase string
+
+	// Public key file for asymmetric algorithms.
+	// Optional, derived from the private key when neither PubKeyFile nor PubKeyBytes is set.
+	PubKeyFile string
+
+	// Public key bytes for asymmetric algorithms.
+	//
+	// Note: PubKeyFile takes precedence over PubKeyBytes if both are set
+	PubKeyBytes []byte
+
+	// Time from which the key signs new tokens and verifies tokens.
+	// Optional, zero means the key is active immediately.
+	ActivateAt time.Time
+
+	// Time from which the key no longer signs new tokens. It still verifies tokens signed before.
+	// Optional, zero means the key is never retired.
+	RetireAt time.Time
+
+	// Private key
+	privKey crypto.PrivateKey
+
+	// Public key
+	pubKey crypto.PublicKey
+}
+
+// load validates the key and reads its key material.
+func (k *SigningKey) load(defaultAlgorithm string) error {
+	if k.ID == "" {
+		return ErrMissingKeyID
+	}
+
+	if k.Algorithm == "" {
+		k.Algorithm = defaultAlgorithm
+	}
+
+	if !isPublicKeyAlgo(k.Algorithm) {
+		if len(k.Key) == 0 {
+			return ErrMissingSecretKey
+		}
+		return nil
+	}
+
+	if k.PrivKeyFile != "" || len(k.PrivKeyBytes) > 0 {
+		keyData, err := readKeyData(k.PrivKeyFile, k.PrivKeyBytes, ErrNoPrivKeyFile)
+		if err != nil {
+			return err
+		}
+		key, err := parsePrivateKey(keyData, k.PrivateKeyPassphrase)
+		if err != nil {
+			return err
+		}
+		if !keyMatchesAlgorithm(k.Algorithm, key) {
+			return ErrInvalidPrivKey
+		}
+		k.privKey = key
+	}
+
+	if k.PubKeyFile == "" && len(k.PubKeyBytes) == 0 {
+		if k.privKey == nil {
+			return ErrInvalidPubKey
+		}
+		k.pubKey = publicOf(k.privKey)
+		return nil
+	}
+
+	keyData, err := readKeyData(k.PubKeyFile, k.PubKeyBytes, ErrNoPubKeyFile)
+	if err != nil {
+		return err
+	}
+	key, err := parsePublicKey(keyData)
+	if err != nil {
+		return err
+	}
+	if !keyMatchesAlgorithm(k.Algorithm, key) {
+		return ErrInvalidPubKey
+	}
+	k.pubKey = key
+	return nil
+}
+
+// canSign reports whether the key holds the material needed to sign tokens.
+func (k *SigningKey) canSign() bool {
+	if isPublicKeyAlgo(k.Algorithm) {
+		return k.privKey != nil
+	}
+	return len(k.Key) > 0
+}
+
+// signKey returns the key passed to the signing method.
+func (k *SigningKey) signKey() interface{} {
+	if isPublicKeyAlgo(k.Algorithm) {
+		return k.privKey
+	}
+	return k.Key
+}
+
+// verifyKey returns the key passed to the signing method for verification.
+func (k *SigningKey) verifyKey() interface{} {
+	if isPublicKeyAlgo(k.Algorithm) {
+		return k.pubKey
+	}
+	return k.Key
+}
+
+// activeAt reports whether the key is active at the given time.
+func (k *SigningKey) activeAt(now time.Time) bool {
+	return k.ActivateAt.IsZero() || !now.Before(k.ActivateAt)
+}
+
+// retiredAt reports whether the key is retired at the given time.
+func (k *SigningKey) retiredAt(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// readKeyData reads key material from file, or returns data if file is empty.
+func readKeyData(file string, data []byte, errNoFile error) ([]byte, error) {
+	if file == "" {
+		return data, nil
+	}
+	fileContent, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errNoFile
+	}
+	return fileContent, nil
+}
+
+// isPublicKeyAlgo reports whether alg is an asymmetric signing algorithm.
+func isPublicKeyAlgo(alg string) bool {
+	switch alg {
+	case "RS256", "RS512", "RS384",
+		"PS256", "PS512", "PS384",
+		"ES256", "ES512", "ES384",
+		"EdDSA":
+		return true
+	}
+	return false
+}
+
+// parsePrivateKey parses a PEM encoded private key in PKCS#1, PKCS#8 or SEC 1 form.
+// Encrypted PEM blocks are decrypted with passphrase.
+func parsePrivateKey(data []byte, passphrase string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPrivKey
+	}
+
+	der := block.Bytes
+	//nolint:staticcheck
+	if passphrase != "" && x509.IsEncryptedPEMBlock(block) {
+		decrypted, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+		if err != nil {
+			return nil, ErrInvalidPrivKey
+		}
+		der = decrypted
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, ErrInvalidPrivKey
+}
+
+// parsePublicKey parses a PEM encoded public key in PKIX or PKCS#1 form,
+// or takes the public key of a PEM encoded certificate.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPubKey
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+
+	return nil, ErrInvalidPubKey
+}
+
+// publicOf returns the public half of a private key.
+func publicOf(key crypto.PrivateKey) crypto.PublicKey {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return nil
+}
+
+// keyMatchesAlgorithm reports whether key, private or public, can be used with the
+// given signing algorithm.
+func keyMatchesAlgorithm(alg string, key interface{}) bool {
+	if priv, ok := key.(crypto.Signer); ok {
+		key = priv.Public()
+	}
+
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case "ES256", "ES384", "ES512":
+		k, ok := key.(*ecdsa.PublicKey)
+		return ok && k.Curve == curveOf(alg)
+	case "EdDSA":
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// curveOf returns the elliptic curve required by an ECDSA signing algorithm.
+func curveOf(alg string) elliptic.Curve {
+	switch alg {
+	case "ES256":
+		return elliptic.P256()
+	case "ES384":
+		return elliptic.P384()
+	case "ES512":
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// readKeyring validates the keyring and reads the key material of every key.
+func (mw *GfJWTMiddleware) readKeyring() error {
+	ids := make(map[string]struct{}, len(mw.Keys)+1)
+	if mw.KeyID != "" {
+		ids[mw.KeyID] = struct{}{}
+	}
+	for _, key := range mw.Keys {
+		if err := key.load(mw.SigningAlgorithm); err != nil {
+			return err
+		}
+		if jwt.GetSigningMethod(key.Algorithm) == nil {
+			return ErrInvalidSigningAlgorithm
+		}
+		if _, ok := ids[key.ID]; ok {
+			return ErrDuplicateKeyID
+		}
+		ids[key.ID] = struct{}{}
+	}
+	return nil
+}
+
+// activeKey returns the keyring key that signs new tokens, which is the most recently activated
+// key that has not been retired. It returns nil if there is no such key.
+func (mw *GfJWTMiddleware) activeKey() *SigningKey {
+	var (
+		now    = mw.TimeFunc()
+		active *SigningKey
+	)
+	for _, key := range mw.Keys {
+		if !key.canSign() || !key.activeAt(now) || key.retiredAt(now) {
+			continue
+		}
+		if active == nil || key.ActivateAt.After(active.ActivateAt) {
+			active = key
+		}
+	}
+	return active
+}
+
+// lookupKey returns the keyring key with the given ID, or nil if there is no such key.
+func (mw *GfJWTMiddleware) lookupKey(kid string) *SigningKey {
+	for _, key := range mw.Keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newKeyPEM encodes key in PKCS#8 PEM.
+func newKeyPEM(t *testing.T, key crypto.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// newPublicKeyPEM encodes the public half of key in PKIX PEM.
+func newPublicKeyPEM(t *testing.T, key crypto.PrivateKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicOf(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// newMiddlewareError returns the error New panics with, if any.
+func newMiddlewareError(mw *GfJWTMiddleware) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err, _ = v.(error)
+		}
+	}()
+	newTestMiddleware(mw)
+	return nil
+}
+
+func TestKeyMatchesAlgorithm(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(keyMatchesAlgorithm("RS256", rsaKey), true)
+		t.Assert(keyMatchesAlgorithm("PS512", &rsaKey.PublicKey), true)
+		t.Assert(keyMatchesAlgorithm("ES256", p256), true)
+		t.Assert(keyMatchesAlgorithm("ES384", &p384.PublicKey), true)
+		t.Assert(keyMatchesAlgorithm("EdDSA", edKey), true)
+		t.Assert(keyMatchesAlgorithm("EdDSA", edKey.Public()), true)
+
+		t.Assert(keyMatchesAlgorithm("ES256", p384), false)
+		t.Assert(keyMatchesAlgorithm("ES512", p256), false)
+		t.Assert(keyMatchesAlgorithm("RS256", p256), false)
+		t.Assert(keyMatchesAlgorithm("ES256", rsaKey), false)
+		t.Assert(keyMatchesAlgorithm("EdDSA", rsaKey), false)
+		t.Assert(keyMatchesAlgorithm("HS256", []byte("secret key")), false)
+	})
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := map[string]crypto.PrivateKey{
+		"RS256": rsaKey,
+		"PS384": rsaKey,
+		"ES256": p256,
+		"ES384": p384,
+		"ES512": p521,
+		"EdDSA": edKey,
+	}
+	for alg, key := range keys {
+		gtest.C(t, func(t *gtest.T) {
+			t.Log(alg)
+			issuer := newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: alg, PrivKeyBytes: newKeyPEM(t.T, key)})
+			verifier := newTestMiddleware(&GfJWTMiddleware{
+				SigningAlgorithm: alg,
+				PrivKeyBytes:     newKeyPEM(t.T, key),
+				PubKeyBytes:      newPublicKeyPEM(t.T, key),
+			})
+
+			token, _, err := issuer.TokenGenerator(MapClaims{"identity": "a"})
+			t.AssertNil(err)
+			parsed, err := verifier.parseTokenString(ctx, token)
+			t.AssertNil(err)
+			t.Assert(parsed.Header["alg"], alg)
+		})
+	}
+
+	gtest.C(t, func(t *gtest.T) {
+		// SEC 1 keys are read too
+		mw := newTestMiddleware(&GfJWTMiddleware{SigningAlgorithm: "ES256", PrivKeyBytes: newECKeyPEM(t.T)})
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		_, err = mw.parseTokenString(ctx, token)
+		t.AssertNil(err)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		// keys of another algorithm or curve are rejected when the middleware is created
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{SigningAlgorithm: "ES384", PrivKeyBytes: newKeyPEM(t.T, p256)}), ErrInvalidPrivKey)
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{SigningAlgorithm: "RS256", PrivKeyBytes: newKeyPEM(t.T, edKey)}), ErrInvalidPrivKey)
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{
+			SigningAlgorithm: "EdDSA",
+			PrivKeyBytes:     newKeyPEM(t.T, edKey),
+			PubKeyBytes:      newPublicKeyPEM(t.T, rsaKey),
+		}), ErrInvalidPubKey)
+	})
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	publicPEM := newPublicKeyPEM(t, rsaKey)
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		SigningAlgorithm: "RS256",
+		PrivKeyBytes:     newKeyPEM(t, rsaKey),
+		PubKeyBytes:      publicPEM,
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		claims := jwt.MapClaims{"identity": "a", "exp": time.Now().Add(time.Hour).UnixNano() / 1e6}
+
+		// the public key is known to anyone, and must not verify HMAC tokens
+		hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(publicPEM)
+		t.AssertNil(err)
+		_, err = mw.parseTokenString(ctx, hmac)
+		t.Assert(errors.Is(err, ErrInvalidSigningAlgorithm), true)
+
+		none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		t.AssertNil(err)
+		_, err = mw.parseTokenString(ctx, none)
+		t.AssertNE(err, nil)
+	})
+}
+
+func TestKeyring(t *testing.T) {
+	clock := newTestClock()
+	start := clock.Now()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		TimeFunc: clock.Now,
+		Keys: []*SigningKey{
+			{ID: "k1", Key: []byte("key one"), RetireAt: start.Add(time.Hour)},
+			{ID: "k2", Key: []byte("key two"), ActivateAt: start.Add(time.Hour)},
+		},
+	})
+	sign := func(alg, kid string, key []byte) string {
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), jwt.MapClaims{"identity": "a"})
+		token.Header["kid"] = kid
+		s, _ := token.SignedString(key)
+		return s
+	}
+
+	gtest.C(t, func(t *gtest.T) {
+		before, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		parsed, err := mw.parseTokenString(ctx, before)
+		t.AssertNil(err)
+		t.Assert(parsed.Header["kid"], "k1")
+
+		// keys do not verify before their activation
+		_, err = mw.parseTokenString(ctx, sign("HS256", "k2", []byte("key two")))
+		t.Assert(errors.Is(err, ErrUnknownKeyID), true)
+
+		clock.Add(time.Hour)
+		after, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		parsed, err = mw.parseTokenString(ctx, after)
+		t.AssertNil(err)
+		t.Assert(parsed.Header["kid"], "k2")
+
+		// retired keys still verify the tokens they signed
+		_, err = mw.parseTokenString(ctx, before)
+		t.AssertNil(err)
+
+		_, err = mw.parseTokenString(ctx, sign("HS256", "k3", []byte("key one")))
+		t.Assert(errors.Is(err, ErrUnknownKeyID), true)
+		_, err = mw.parseTokenString(ctx, sign("HS384", "k1", []byte("key one")))
+		t.Assert(errors.Is(err, ErrInvalidSigningAlgorithm), true)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		err := newMiddlewareError(&GfJWTMiddleware{Keys: []*SigningKey{
+			{ID: "k1", Key: []byte("key one")},
+			{ID: "k1", Key: []byte("key two")},
+		}})
+		t.Assert(err, ErrDuplicateKeyID)
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{Keys: []*SigningKey{{Key: []byte("key one")}}}), ErrMissingKeyID)
+	})
+}
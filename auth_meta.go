@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/text/gregex"
+	"github.com/gogf/gf/v2/util/gmeta"
+)
+
+const (
+	// metaAuth is the g.Meta tag telling whether a route requires authentication:
+	// "required", the default, "optional", or "none".
+	metaAuth = "auth"
+
+	// metaRoles is the g.Meta tag listing the roles of which the identity needs any, separated by commas.
+	metaRoles = "roles"
+
+	// metaScopes is the g.Meta tag listing the scopes the token needs all of, separated by spaces or commas.
+	metaScopes = "scopes"
+)
+
+const (
+	authRequired = "required"
+	authOptional = "optional"
+	authNone     = "none"
+)
+
+// metaRoute is a service route with the g.Meta tags of the request struct of its handler.
+type metaRoute struct {
+	router *ghttp.Router
+	meta   map[string]string
+}
+
+// matches reports whether the route serves requests with the method and path of r.
+func (route metaRoute) matches(r *ghttp.Request) bool {
+	if route.router.Method != "ALL" && route.router.Method != r.Method {
+		return false
+	}
+	if route.router.Domain != ghttp.DefaultDomainName && route.router.Domain != r.GetHost() {
+		return false
+	}
+	return gregex.IsMatchString(route.router.RegRule, r.URL.Path)
+}
+
+// resolveRoute returns the service route serving the request, with the g.Meta tags of the request
+// struct of its handler, or nil if the request is served by no route. The router of the request is
+// that of the serving handler for middleware bound to route groups, but that of the middleware
+// itself for global middleware: the serving route is then matched by the method and path of the
+// request, ranked as gf does, and ErrAmbiguousRoute is returned if routes with different g.Meta
+// tags match it, or routes of equal rank.
+func (mw *GfJWTMiddleware) resolveRoute(r *ghttp.Request) (*metaRoute, error) {
+	if r.Server == nil {
+		return nil, nil
+	}
+
+	routes := mw.metaRoutes(r.Server)
+	if r.Router != nil {
+		for i := range routes {
+			if routes[i].router == r.Router {
+				return &routes[i], nil
+			}
+		}
+	}
+
+	var resolved *metaRoute
+	for i := range routes {
+		route := &routes[i]
+		if !route.matches(r) {
+			continue
+		}
+		if resolved == nil {
+			resolved = route
+			continue
+		}
+		if !reflect.DeepEqual(resolved.meta, route.meta) {
+			return nil, ErrAmbiguousRoute
+		}
+		switch compareRoutes(route.router, resolved.router) {
+		case 0:
+			if route.router.Uri != resolved.router.Uri {
+				return nil, ErrAmbiguousRoute
+			}
+		case 1:
+			resolved = route
+		}
+	}
+	return resolved, nil
+}
+
+// compareRoutes ranks routes matching the same request as gf does: deeper routes first, then
+// those with longer static parts, then those with fewer fuzzy parts. It returns 1 if a ranks
+// before b, -1 if b ranks before a, and 0 if they rank equal.
+func compareRoutes(a, b *ghttp.Router) int {
+	if a.Priority != b.Priority {
+		return sign(a.Priority - b.Priority)
+	}
+	staticA := len(routeFuzzyPart.ReplaceAllString(a.Uri, ""))
+	staticB := len(routeFuzzyPart.ReplaceAllString(b.Uri, ""))
+	if staticA != staticB {
+		return sign(staticA - staticB)
+	}
+	fuzzyA := strings.Count(a.Uri, "{") + strings.Count(a.Uri, ":") + strings.Count(a.Uri, "*")
+	fuzzyB := strings.Count(b.Uri, "{") + strings.Count(b.Uri, ":") + strings.Count(b.Uri, "*")
+	return sign(fuzzyB - fuzzyA)
+}
+
+// routeFuzzyPart matches the fuzzy parts of route patterns: "{field}", ":name" and "*any".
+var routeFuzzyPart = regexp.MustCompile(`\{[^/]+?\}|:[^/]+?|\*[^/]*`)
+
+// sign returns the sign of n.
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+// metaRoutes returns the service routes of the server, which are scanned once for every server.
+func (mw *GfJWTMiddleware) metaRoutes(s *ghttp.Server) []metaRoute {
+	if routes, ok := mw.routeMetas.Load(s); ok {
+		return routes.([]metaRoute)
+	}
+
+	var routes []metaRoute
+	for _, item := range s.GetRoutes() {
+		if item.Handler == nil || item.Handler.Router == nil {
+			continue
+		}
+		if item.Handler.Type != ghttp.HandlerTypeHandler && item.Handler.Type != ghttp.HandlerTypeObject {
+			continue
+		}
+		var meta map[string]string
+		if t := item.Handler.Info.Type; t != nil && t.NumIn() == 2 {
+			meta = gmeta.Data(reflect.New(t.In(1)))
+		}
+		if len(meta) == 0 {
+			meta = nil
+		}
+		routes = append(routes, metaRoute{router: item.Handler.Router, meta: meta})
+	}
+
+	actual, _ := mw.routeMetas.LoadOrStore(s, routes)
+	return actual.([]metaRoute)
+}
+
+// authorizeRoute checks the roles and scopes of the token against those required by the
+// g.Meta tags of the route.
+func authorizeRoute(roles []string, scopes []string, meta map[string]string) error {
+	if required := splitMetaList(meta[metaRoles]); len(required) > 0 && !containsAny(roles, required) {
+		return ErrForbidden
+	}
+
+	if required := splitMetaList(meta[metaScopes]); len(required) > 0 && !containsAll(scopes, required) {
+		return &scopeError{scopes: required}
+	}
+
+	return nil
+}
+
+// splitMetaList splits a g.Meta tag listing values separated by commas or spaces.
+func splitMetaList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,92 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+type metaAdminReq struct {
+	g.Meta `method:"get" roles:"admin"`
+}
+
+type metaPublicReq struct {
+	g.Meta `method:"get" auth:"none"`
+}
+
+type metaItemReq struct {
+	g.Meta `method:"get" roles:"admin"`
+	Id     string
+}
+
+type metaRes struct{}
+
+func metaAdmin(ctx context.Context, req *metaAdminReq) (*metaRes, error)   { return &metaRes{}, nil }
+func metaPublic(ctx context.Context, req *metaPublicReq) (*metaRes, error) { return &metaRes{}, nil }
+func metaItem(ctx context.Context, req *metaItemReq) (*metaRes, error)     { return &metaRes{}, nil }
+
+// bindMetaRoutes binds the routes with g.Meta tags; "/item/list" matches both item routes.
+func bindMetaRoutes(group *ghttp.RouterGroup) {
+	group.GET("/admin", metaAdmin)
+	group.GET("/public", metaPublic)
+	group.GET("/item/{id}", metaItem)
+	group.GET("/item/list", metaPublic)
+}
+
+func TestRouteMeta(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{})
+
+	servers := map[string]*gclient.Client{
+		"global": newTestServer(t, func(s *ghttp.Server) {
+			s.Use(authMiddleware(mw))
+			s.Group("/", bindMetaRoutes)
+		}),
+		"group": newTestServer(t, func(s *ghttp.Server) {
+			s.Group("/", func(group *ghttp.RouterGroup) {
+				group.Middleware(authMiddleware(mw))
+				bindMetaRoutes(group)
+			})
+		}),
+	}
+
+	status := func(c *gclient.Client, token, path string) int {
+		if token != "" {
+			c = bearer(c, token)
+		}
+		resp, err := c.Get(ctx, path)
+		if err != nil {
+			return 0
+		}
+		defer resp.Close()
+		return resp.StatusCode
+	}
+
+	for binding, c := range servers {
+		gtest.C(t, func(t *gtest.T) {
+			admin, _, err := mw.TokenGenerator(MapClaims{"identity": "a", "roles": "admin"})
+			t.AssertNil(err)
+			user, _, err := mw.TokenGenerator(MapClaims{"identity": "b"})
+			t.AssertNil(err)
+
+			t.Log(binding)
+			t.Assert(status(c, "", "/admin"), http.StatusUnauthorized)
+			t.Assert(status(c, user, "/admin"), http.StatusForbidden)
+			t.Assert(status(c, admin, "/admin"), http.StatusOK)
+			t.Assert(status(c, "", "/public"), http.StatusOK)
+			t.Assert(status(c, user, "/item/1"), http.StatusForbidden)
+		})
+	}
+
+	gtest.C(t, func(t *gtest.T) {
+		user, _, _ := mw.TokenGenerator(MapClaims{"identity": "b"})
+
+		// global middleware can not tell which of the matching routes serves the request
+		t.Assert(status(servers["global"], user, "/item/list"), http.StatusInternalServerError)
+		t.Assert(status(servers["group"], user, "/item/list"), http.StatusOK)
+	})
+}
@@ -0,0 +1,172 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// PolicyRequest is a request to authorize, as given to a PolicyEnforcer.
+type PolicyRequest struct {
+	// Identity set by IdentityHandler
+	Identity interface{}
+
+	// Claims of the token
+	Claims MapClaims
+
+	// Roles of the identity, parsed from the RolesKey claim
+	Roles []string
+
+	// Scopes of the token, parsed from the ScopesKey claim
+	Scopes []string
+
+	// HTTP method of the request
+	Method string
+
+	// Path of the request
+	Path string
+
+	// Route pattern of the handler serving the request, such as "/user/{id}"
+	Route string
+
+	// Meta tags of the request struct of the handler serving the request
+	Meta map[string]string
+}
+
+// PolicyDecision is the decision of a PolicyEnforcer on a request.
+type PolicyDecision struct {
+	// Allow tells whether the request is authorized
+	Allow bool
+
+	// Reason of the decision, e.g. the rule that allowed or denied the request, which is logged
+	// for denied requests
+	Reason string
+}
+
+// PolicyEnforcer decides whether requests are authorized, with the route they are for.
+// Denied requests are rejected with 403 and ErrPolicyDenied, as are requests the enforcer
+// fails on. The reason of the decision and the failure are logged.
+type PolicyEnforcer interface {
+	Enforce(ctx context.Context, req *PolicyRequest) (PolicyDecision, error)
+}
+
+// rbacEnforcer is a PolicyEnforcer granting routes to roles.
+type rbacEnforcer struct {
+	roles map[string][]pathRule
+}
+
+// NewRBACEnforcer creates a PolicyEnforcer that allows a request if any role of the identity is
+// granted a pattern matching the request. Patterns are in gf router syntax, optionally preceded
+// by methods, as in IncludePaths: {"admin": {"/admin/*"}, "user": {"GET:/api/*"}}.
+func NewRBACEnforcer(roles map[string][]string) (PolicyEnforcer, error) {
+	enforcer := &rbacEnforcer{roles: make(map[string][]pathRule, len(roles))}
+	for role, patterns := range roles {
+		rules, err := compilePathRules(patterns)
+		if err != nil {
+			return nil, err
+		}
+		enforcer.roles[role] = rules
+	}
+	return enforcer, nil
+}
+
+// NewRBACEnforcerFromConfig creates the PolicyEnforcer of NewRBACEnforcer with the roles configured
+// under pattern in the default configuration, such as:
+//
+//	jwt:
+//	  rbac:
+//	    admin: ["/admin/*"]
+//	    user: ["GET:/api/*"]
+func NewRBACEnforcerFromConfig(ctx context.Context, pattern string) (PolicyEnforcer, error) {
+	v, err := g.Cfg().Get(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string][]string)
+	for role, patterns := range v.Map() {
+		roles[role] = gconv.Strings(patterns)
+	}
+	return NewRBACEnforcer(roles)
+}
+
+// Enforce allows the request if any role of the identity is granted its method and path.
+func (e *rbacEnforcer) Enforce(ctx context.Context, req *PolicyRequest) (PolicyDecision, error) {
+	for _, role := range req.Roles {
+		for _, rule := range e.roles[role] {
+			if rule.match(req.Method, req.Path) {
+				return PolicyDecision{Allow: true, Reason: "granted to role " + role}, nil
+			}
+		}
+	}
+	return PolicyDecision{Reason: fmt.Sprintf("no role of the identity is granted %s %s", req.Method, req.Path)}, nil
+}
+
+// CasbinEnforcer is the method of *casbin.Enforcer used by NewCasbinEnforcer, so that casbin is
+// not a dependency of this package. If the enforcer also has the EnforceEx method of casbin,
+// the matched policy is given as the reason of decisions.
+type CasbinEnforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+// casbinExplainer is the EnforceEx method of *casbin.Enforcer.
+type casbinExplainer interface {
+	EnforceEx(rvals ...interface{}) (bool, []string, error)
+}
+
+// casbinPolicyEnforcer is a PolicyEnforcer backed by casbin.
+type casbinPolicyEnforcer struct {
+	enforcer CasbinEnforcer
+}
+
+// NewCasbinEnforcer creates a PolicyEnforcer backed by a casbin enforcer, loaded from model and
+// policy files with casbin.NewEnforcer. Requests are enforced as (identity, path, method), the
+// request of the usual "sub, obj, act" model.
+func NewCasbinEnforcer(enforcer CasbinEnforcer) PolicyEnforcer {
+	return &casbinPolicyEnforcer{enforcer: enforcer}
+}
+
+// Enforce asks casbin whether the identity may use the method on the path.
+func (e *casbinPolicyEnforcer) Enforce(ctx context.Context, req *PolicyRequest) (PolicyDecision, error) {
+	subject := gconv.String(req.Identity)
+
+	if explainer, ok := e.enforcer.(casbinExplainer); ok {
+		allow, explain, err := explainer.EnforceEx(subject, req.Path, req.Method)
+		if err != nil {
+			return PolicyDecision{}, err
+		}
+		reason := "no casbin policy matches"
+		if len(explain) > 0 {
+			reason = "casbin policy " + strings.Join(explain, ", ")
+		}
+		return PolicyDecision{Allow: allow, Reason: reason}, nil
+	}
+
+	allow, err := e.enforcer.Enforce(subject, req.Path, req.Method)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	return PolicyDecision{Allow: allow, Reason: "casbin"}, nil
+}
+
+// enforcePolicy asks PolicyEnforcer to authorize the request. The reason of denials and the
+// failures of the enforcer are logged, not told to the client, which gets ErrPolicyDenied.
+func (mw *GfJWTMiddleware) enforcePolicy(ctx context.Context, req *PolicyRequest) error {
+	if mw.PolicyEnforcer == nil {
+		return nil
+	}
+
+	decision, err := mw.PolicyEnforcer.Enforce(ctx, req)
+	if err != nil {
+		g.Log().Errorf(ctx, "jwt: policy enforcer failed on %s %s: %v", req.Method, req.Path, err)
+		return ErrPolicyDenied
+	}
+	if !decision.Allow {
+		g.Log().Infof(ctx, "jwt: policy denied %s %s: %s", req.Method, req.Path, decision.Reason)
+		return ErrPolicyDenied
+	}
+	return nil
+}
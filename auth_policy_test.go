@@ -0,0 +1,251 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// recordingEnforcer records the requests it is asked about, and decides them with decide.
+type recordingEnforcer struct {
+	mu       sync.Mutex
+	requests []*PolicyRequest
+	decide   func(req *PolicyRequest) (PolicyDecision, error)
+}
+
+func (e *recordingEnforcer) Enforce(ctx context.Context, req *PolicyRequest) (PolicyDecision, error) {
+	e.mu.Lock()
+	e.requests = append(e.requests, req)
+	e.mu.Unlock()
+	return e.decide(req)
+}
+
+// last returns the last request the enforcer was asked about.
+func (e *recordingEnforcer) last() *PolicyRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.requests) == 0 {
+		return nil
+	}
+	return e.requests[len(e.requests)-1]
+}
+
+func TestPolicyRoute(t *testing.T) {
+	enforcer := &recordingEnforcer{decide: func(req *PolicyRequest) (PolicyDecision, error) {
+		return PolicyDecision{Allow: true}, nil
+	}}
+	mw := newTestMiddleware(&GfJWTMiddleware{PolicyEnforcer: enforcer})
+	hello := func(r *ghttp.Request) { r.Response.Write("hello") }
+	bind := func(group *ghttp.RouterGroup) {
+		group.GET("/user/{id}", hello)
+		group.GET("/user/list", hello)
+		group.GET("/item/*any", hello)
+	}
+
+	global := newTestServer(t, func(s *ghttp.Server) {
+		s.Use(authMiddleware(mw))
+		s.Group("/", bind)
+	})
+	group := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			bind(group)
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		// the route is that of the serving handler, whether the middleware is global or not
+		for path, route := range map[string]string{
+			"/user/1":    "/user/{id}",
+			"/user/list": "/user/list",
+			"/item/a/b":  "/item/*any",
+		} {
+			t.Assert(bearer(global, token).GetContent(ctx, path), "hello")
+			t.Assert(enforcer.last().Route, route)
+			t.Assert(enforcer.last().Path, path)
+			t.Assert(enforcer.last().Method, http.MethodGet)
+
+			t.Assert(bearer(group, token).GetContent(ctx, path), "hello")
+			t.Assert(enforcer.last().Route, route)
+		}
+	})
+}
+
+func TestPolicyDenied(t *testing.T) {
+	var denial error
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		PolicyEnforcer: &recordingEnforcer{decide: func(req *PolicyRequest) (PolicyDecision, error) {
+			if denial != nil {
+				return PolicyDecision{}, denial
+			}
+			return PolicyDecision{Reason: "secret rule 42"}, nil
+		}},
+	})
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		// neither the reason of the decision nor the failure of the enforcer reach the client
+		for _, e := range []error{nil, errors.New("dial tcp 10.0.0.1:5432: connection refused")} {
+			denial = e
+			resp, err := bearer(c, token).Get(ctx, "/hello")
+			t.AssertNil(err)
+			body := resp.ReadAllString()
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Close()
+
+			t.Assert(resp.StatusCode, http.StatusForbidden)
+			t.Assert(strings.Contains(body, ErrPolicyDenied.Error()), true)
+			t.Assert(strings.Contains(challenge, `error_description="`+ErrPolicyDenied.Error()+`"`), true)
+			for _, leak := range []string{"secret rule", "10.0.0.1"} {
+				t.Assert(strings.Contains(body, leak), false)
+				t.Assert(strings.Contains(challenge, leak), false)
+			}
+		}
+	})
+}
+
+func TestRBACEnforcer(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		enforcer, err := NewRBACEnforcer(map[string][]string{
+			"admin": {"/admin/*"},
+			"user":  {"GET:/api/*"},
+		})
+		t.AssertNil(err)
+
+		allowed := func(method, path string, roles ...string) bool {
+			decision, err := enforcer.Enforce(ctx, &PolicyRequest{Roles: roles, Method: method, Path: path})
+			t.AssertNil(err)
+			return decision.Allow
+		}
+		t.Assert(allowed(http.MethodPost, "/admin/users", "admin"), true)
+		t.Assert(allowed(http.MethodGet, "/admin/users", "user"), false)
+		t.Assert(allowed(http.MethodGet, "/api/items", "user"), true)
+		t.Assert(allowed(http.MethodPost, "/api/items", "user"), false)
+		t.Assert(allowed(http.MethodPost, "/api/items", "user", "admin"), false)
+		t.Assert(allowed(http.MethodGet, "/api/items", "guest"), false)
+		t.Assert(allowed(http.MethodGet, "/api/items"), false)
+	})
+}
+
+func TestRBACEnforcerFromConfig(t *testing.T) {
+	adapter := g.Cfg().GetAdapter().(*gcfg.AdapterFile)
+	adapter.SetContent(`{"jwt": {"rbac": {"admin": ["/admin/*"], "user": ["GET:/api/*", "GET:/me"]}}}`)
+	defer adapter.ClearContent()
+
+	gtest.C(t, func(t *gtest.T) {
+		enforcer, err := NewRBACEnforcerFromConfig(ctx, "jwt.rbac")
+		t.AssertNil(err)
+
+		allowed := func(method, path string, roles ...string) bool {
+			decision, err := enforcer.Enforce(ctx, &PolicyRequest{Roles: roles, Method: method, Path: path})
+			t.AssertNil(err)
+			return decision.Allow
+		}
+		t.Assert(allowed(http.MethodDelete, "/admin/users", "admin"), true)
+		t.Assert(allowed(http.MethodGet, "/me", "user"), true)
+		t.Assert(allowed(http.MethodGet, "/api/items", "user"), true)
+		t.Assert(allowed(http.MethodGet, "/admin/users", "user"), false)
+
+		// patterns missing from the configuration grant nothing
+		enforcer, err = NewRBACEnforcerFromConfig(ctx, "jwt.missing")
+		t.AssertNil(err)
+		t.Assert(allowed(http.MethodGet, "/api/items", "user"), false)
+	})
+}
+
+// fakeCasbin is a casbin enforcer granting subjects "path method" pairs.
+type fakeCasbin struct {
+	policies map[string][]string
+	err      error
+	calls    [][]interface{}
+}
+
+func (e *fakeCasbin) Enforce(rvals ...interface{}) (bool, error) {
+	allow, _, err := e.enforce(rvals)
+	return allow, err
+}
+
+func (e *fakeCasbin) enforce(rvals []interface{}) (bool, []string, error) {
+	e.calls = append(e.calls, rvals)
+	if e.err != nil {
+		return false, nil, e.err
+	}
+	sub, obj, act := gconv.String(rvals[0]), gconv.String(rvals[1]), gconv.String(rvals[2])
+	for _, policy := range e.policies[sub] {
+		if policy == obj+" "+act {
+			return true, []string{sub, obj, act}, nil
+		}
+	}
+	return false, nil, nil
+}
+
+// fakeCasbinEx also explains its decisions, as *casbin.Enforcer does with EnforceEx.
+type fakeCasbinEx struct {
+	fakeCasbin
+}
+
+func (e *fakeCasbinEx) EnforceEx(rvals ...interface{}) (bool, []string, error) {
+	return e.enforce(rvals)
+}
+
+func TestCasbinEnforcer(t *testing.T) {
+	policies := map[string][]string{"alice": {"/data GET"}}
+
+	gtest.C(t, func(t *gtest.T) {
+		casbin := &fakeCasbin{policies: policies}
+		enforcer := NewCasbinEnforcer(casbin)
+
+		// requests are enforced as (identity, path, method)
+		decision, err := enforcer.Enforce(ctx, &PolicyRequest{Identity: "alice", Method: http.MethodGet, Path: "/data"})
+		t.AssertNil(err)
+		t.Assert(decision.Allow, true)
+		t.Assert(casbin.calls[0], []interface{}{"alice", "/data", http.MethodGet})
+
+		decision, err = enforcer.Enforce(ctx, &PolicyRequest{Identity: "alice", Method: http.MethodPost, Path: "/data"})
+		t.AssertNil(err)
+		t.Assert(decision.Allow, false)
+		decision, err = enforcer.Enforce(ctx, &PolicyRequest{Identity: "bob", Method: http.MethodGet, Path: "/data"})
+		t.AssertNil(err)
+		t.Assert(decision.Allow, false)
+
+		casbin.err = errors.New("adapter failed")
+		_, err = enforcer.Enforce(ctx, &PolicyRequest{Identity: "alice", Method: http.MethodGet, Path: "/data"})
+		t.Assert(err, casbin.err)
+	})
+
+	// enforcers with EnforceEx give the matched policy as the reason
+	gtest.C(t, func(t *gtest.T) {
+		casbin := &fakeCasbinEx{fakeCasbin{policies: policies}}
+		enforcer := NewCasbinEnforcer(casbin)
+
+		decision, err := enforcer.Enforce(ctx, &PolicyRequest{Identity: "alice", Method: http.MethodGet, Path: "/data"})
+		t.AssertNil(err)
+		t.Assert(decision.Allow, true)
+		t.Assert(decision.Reason, "casbin policy alice, /data, GET")
+
+		decision, err = enforcer.Enforce(ctx, &PolicyRequest{Identity: "bob", Method: http.MethodGet, Path: "/data"})
+		t.AssertNil(err)
+		t.Assert(decision.Allow, false)
+		t.Assert(decision.Reason, "no casbin policy matches")
+	})
+}
@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ProblemDetailsMode tells when failures are responded with RFC 7807 Problem Details.
+type ProblemDetailsMode int
+
+const (
+	// ProblemDetailsNever responds with {"code", "message"}, the default.
+	ProblemDetailsNever ProblemDetailsMode = iota
+
+	// ProblemDetailsAlways responds with Problem Details.
+	ProblemDetailsAlways
+
+	// ProblemDetailsAccept responds with Problem Details to requests accepting "application/problem+json",
+	// and with {"code", "message"} otherwise.
+	ProblemDetailsAccept
+)
+
+// problemContentType is the media type of Problem Details.
+const problemContentType = "application/problem+json"
+
+// Type URIs of the Problem Details of failures, which are stable identifiers rather than
+// documentation links. Failures of other kinds have the type "about:blank".
+const (
+	// ProblemTypeMissingToken is the type of requests without a token.
+	ProblemTypeMissingToken = "urn:gf-jwt:problem:missing-token"
+
+	// ProblemTypeMalformedToken is the type of tokens, or of auth headers, that can not be parsed.
+	ProblemTypeMalformedToken = "urn:gf-jwt:problem:malformed-token"
+
+	// ProblemTypeExpiredToken is the type of tokens that have expired, or whose session is idle.
+	ProblemTypeExpiredToken = "urn:gf-jwt:problem:expired-token"
+
+	// ProblemTypeRevokedToken is the type of tokens that have been revoked or reused.
+	ProblemTypeRevokedToken = "urn:gf-jwt:problem:revoked-token"
+
+	// ProblemTypeInvalidToken is the type of tokens that are invalid otherwise, such as by their signature or claims.
+	ProblemTypeInvalidToken = "urn:gf-jwt:problem:invalid-token"
+
+	// ProblemTypeForbidden is the type of requests that the identity or token is not granted.
+	ProblemTypeForbidden = "urn:gf-jwt:problem:forbidden"
+)
+
+// Problem is the RFC 7807 Problem Details of a failure.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// problemTypes maps errors to the types and titles of their Problem Details, the first matching
+// error winning. Errors that are not listed have the type "about:blank", titled by their status.
+var problemTypes = []struct {
+	err   error
+	typ   string
+	title string
+}{
+	{ErrEmptyAuthHeader, ProblemTypeMissingToken, "Missing token"},
+	{ErrEmptyQueryToken, ProblemTypeMissingToken, "Missing token"},
+	{ErrEmptyCookieToken, ProblemTypeMissingToken, "Missing token"},
+	{ErrEmptyParamToken, ProblemTypeMissingToken, "Missing token"},
+	{ErrInvalidAuthHeader, ProblemTypeMalformedToken, "Malformed token"},
+	{ErrMissingExpField, ProblemTypeMalformedToken, "Malformed token"},
+	{ErrWrongFormatOfExp, ProblemTypeMalformedToken, "Malformed token"},
+	{ErrInvalidClaims, ProblemTypeMalformedToken, "Malformed token"},
+	{ErrExpiredToken, ProblemTypeExpiredToken, "Token expired"},
+	{ErrIdleTimeout, ProblemTypeExpiredToken, "Token expired"},
+	{ErrRevokedToken, ProblemTypeRevokedToken, "Token revoked"},
+	{ErrRefreshTokenReused, ProblemTypeRevokedToken, "Token revoked"},
+	{ErrInvalidToken, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrInvalidSigningAlgorithm, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrMissingKeyID, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrUnknownKeyID, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrInvalidIssuer, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrInvalidAudience, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrTokenNotValidYet, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrInvalidIssuedAt, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrInvalidTokenType, ProblemTypeInvalidToken, "Invalid token"},
+	{ErrForbidden, ProblemTypeForbidden, "Forbidden"},
+	{ErrInsufficientScope, ProblemTypeForbidden, "Forbidden"},
+	{ErrPolicyDenied, ProblemTypeForbidden, "Forbidden"},
+}
+
+// problemType returns the type and title of the Problem Details of a failure with err and the HTTP status.
+// Errors of the jwt library are told apart by their validation flags.
+func problemType(status int, err error) (string, string) {
+	for _, p := range problemTypes {
+		if errors.Is(err, p.err) {
+			return p.typ, p.title
+		}
+	}
+
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) {
+		switch {
+		case validationErr.Errors&jwt.ValidationErrorMalformed != 0:
+			return ProblemTypeMalformedToken, "Malformed token"
+		case validationErr.Errors&jwt.ValidationErrorExpired != 0:
+			return ProblemTypeExpiredToken, "Token expired"
+		default:
+			return ProblemTypeInvalidToken, "Invalid token"
+		}
+	}
+
+	return "about:blank", http.StatusText(status)
+}
+
+// ProblemUnauthorized is an Unauthorized func responding with the RFC 7807 Problem Details of the
+// failure, with the HTTP status of the failure. The instance is the path of the request.
+func (mw *GfJWTMiddleware) ProblemUnauthorized(ctx context.Context, code int, message string) {
+	r := g.RequestFromCtx(ctx)
+
+	var err error
+	if e := errorFromCtx(ctx); e != nil {
+		err = e.Err
+	}
+	typ, title := problemType(code, err)
+
+	body, _ := json.Marshal(Problem{
+		Type:     typ,
+		Title:    title,
+		Status:   code,
+		Detail:   message,
+		Instance: r.URL.Path,
+	})
+	r.Response.Header().Set("Content-Type", problemContentType)
+	r.Response.WriteHeader(code)
+	r.Response.Write(body)
+}
+
+// acceptsProblem reports whether the Accept header of a request lists "application/problem+json",
+// with a quality other than 0.
+func acceptsProblem(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil || mediaType != problemContentType {
+			continue
+		}
+		if q, ok := params["q"]; ok && gconv.Float64(q) <= 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// errorCtxKey is the context key of the *Error given to Unauthorized.
+type errorCtxKey struct{}
+
+// errorFromCtx returns the *Error of the failure given to Unauthorized, if any.
+func errorFromCtx(ctx context.Context) *Error {
+	e, _ := ctx.Value(errorCtxKey{}).(*Error)
+	return e
+}
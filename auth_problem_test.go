@@ -0,0 +1,138 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// problemOf requests path with token, and returns the status, content type and body of the response.
+func problemOf(c *gclient.Client, token, path string) (int, string, *gjson.Json) {
+	if token != "" {
+		c = bearer(c, token)
+	}
+	resp, err := c.Get(ctx, path)
+	if err != nil {
+		return 0, "", nil
+	}
+	defer resp.Close()
+	return resp.StatusCode, resp.Header.Get("Content-Type"), gjson.New(resp.ReadAll())
+}
+
+func TestProblemType(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		typ := func(err error) string {
+			typ, _ := problemType(http.StatusUnauthorized, err)
+			return typ
+		}
+
+		// revoked tokens are invalid tokens too, but listed before them
+		t.Assert(typ(ErrRevokedToken), ProblemTypeRevokedToken)
+		t.Assert(typ(ErrInvalidToken), ProblemTypeInvalidToken)
+		t.Assert(typ(ErrRefreshTokenReused), ProblemTypeRevokedToken)
+
+		t.Assert(typ(ErrEmptyAuthHeader), ProblemTypeMissingToken)
+		t.Assert(typ(ErrIdleTimeout), ProblemTypeExpiredToken)
+		t.Assert(typ(&scopeError{scopes: []string{"a"}}), ProblemTypeForbidden)
+
+		// errors of the jwt library by their validation flags
+		t.Assert(typ(jwt.NewValidationError("", jwt.ValidationErrorMalformed)), ProblemTypeMalformedToken)
+		t.Assert(typ(jwt.NewValidationError("", jwt.ValidationErrorExpired)), ProblemTypeExpiredToken)
+		t.Assert(typ(jwt.NewValidationError("", jwt.ValidationErrorSignatureInvalid)), ProblemTypeInvalidToken)
+
+		// the others are untyped, titled by their status
+		blank, title := problemType(http.StatusInternalServerError, ErrMissingAuthenticatorFunc)
+		t.Assert(blank, "about:blank")
+		t.Assert(title, http.StatusText(http.StatusInternalServerError))
+	})
+}
+
+func TestProblemUnauthorized(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		ProblemDetails: ProblemDetailsAlways,
+		TimeFunc:       clock.Now,
+	})
+	c := newSessionServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		status, contentType, problem := problemOf(c, "", "/hello")
+		t.Assert(status, http.StatusUnauthorized)
+		t.Assert(contentType, problemContentType)
+		t.Assert(problem.Get("type"), ProblemTypeMissingToken)
+		t.Assert(problem.Get("title"), "Missing token")
+		t.Assert(problem.Get("status"), http.StatusUnauthorized)
+		t.Assert(problem.Get("detail"), ErrEmptyAuthHeader.Error())
+		t.Assert(problem.Get("instance"), "/hello")
+
+		forged, _, err := newTestMiddleware(&GfJWTMiddleware{Key: []byte("other key")}).TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		_, _, problem = problemOf(c, forged, "/hello?a=b")
+		t.Assert(problem.Get("type"), ProblemTypeInvalidToken)
+		t.Assert(problem.Get("instance"), "/hello")
+
+		token, _ := login(c, "a")
+		t.Assert(statusOf(c, token, "/logout"), http.StatusOK)
+		status, _, problem = problemOf(c, token, "/hello")
+		t.Assert(status, http.StatusUnauthorized)
+		t.Assert(problem.Get("type"), ProblemTypeRevokedToken)
+		t.Assert(problem.Get("detail"), ErrInvalidToken.Error())
+
+		token, _ = login(c, "a")
+		clock.Add(2 * time.Hour)
+		_, _, problem = problemOf(c, token, "/hello")
+		t.Assert(problem.Get("type"), ProblemTypeExpiredToken)
+	})
+}
+
+func TestProblemDetailsAccept(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{ProblemDetails: ProblemDetailsAccept})
+	c := newSessionServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		for accept, problem := range map[string]bool{
+			"":                         false,
+			"application/json":         false,
+			"application/problem+json": true,
+			"application/json, application/problem+json; q=0.5": true,
+			"application/problem+json;q=0":                      false,
+		} {
+			resp, err := c.Header(map[string]string{"Accept": accept}).Get(ctx, "/hello")
+			t.AssertNil(err)
+			body := gjson.New(resp.ReadAll())
+			t.Assert(resp.StatusCode, http.StatusUnauthorized)
+			t.Assert(resp.Header.Get("Content-Type") == problemContentType, problem)
+			t.Assert(body.Contains("type"), problem)
+			t.Assert(body.Contains("code"), !problem)
+			resp.Close()
+		}
+	})
+}
+
+func TestHTTPStatusMessageFunc_Revoked(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		ProblemDetails: ProblemDetailsAlways,
+		HTTPStatusMessageFunc: func(e error, ctx context.Context) string {
+			if e == ErrInvalidToken {
+				return "please log in again"
+			}
+			return e.Error()
+		},
+	})
+	c := newSessionServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		// revoked tokens are given to the callback as the invalid tokens they were, yet typed as revoked
+		token, _ := login(c, "a")
+		t.Assert(statusOf(c, token, "/logout"), http.StatusOK)
+		_, _, problem := problemOf(c, token, "/hello")
+		t.Assert(problem.Get("type"), ProblemTypeRevokedToken)
+		t.Assert(problem.Get("detail"), "please log in again")
+	})
+}
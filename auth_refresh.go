@@ -0,0 +1,303 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/util/guid"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// tokenTypeClaim is the claim that tells refresh tokens from access tokens.
+	tokenTypeClaim = "typ"
+
+	// accessTokenType is the token type of access tokens, which carry no type claim.
+	accessTokenType = ""
+
+	// refreshTokenType is the token type of refresh tokens.
+	refreshTokenType = "refresh"
+
+	// familyClaim is the claim that carries the ID of the token family, shared by every
+	// refresh token rotated from one login and the access tokens issued for them.
+	familyClaim = "fid"
+)
+
+// TokenPair is an access token issued together with its refresh token.
+type TokenPair struct {
+	Token         string    `json:"token"`
+	Expire        time.Time `json:"expire"`
+	RefreshToken  string    `json:"refresh_token"`
+	RefreshExpire time.Time `json:"refresh_expire"`
+}
+
+// LoginPairHandler can be used by clients to get an access token and a refresh token.
+// Payload needs to be json in the form of {"username": "USERNAME", "password": "PASSWORD"}.
+// Reply will be of the form {"token": "TOKEN", "refresh_token": "REFRESH_TOKEN"}.
+// Requires RefreshTokenTimeout.
+func (mw *GfJWTMiddleware) LoginPairHandler(ctx context.Context) (pair TokenPair) {
+	if !mw.usingRefreshTokens() {
+		mw.unauthorized(ctx, http.StatusInternalServerError, ErrRefreshTokensDisabled)
+		return
+	}
+
+	claims, tokenString, expire, ok := mw.login(ctx)
+	if !ok {
+		return
+	}
+
+	refreshToken, refreshExpire, err := mw.newRefreshToken(claims)
+	if err != nil {
+		mw.unauthorized(ctx, http.StatusUnauthorized, ErrFailedTokenCreation)
+		return
+	}
+
+	return TokenPair{
+		Token:         tokenString,
+		Expire:        expire,
+		RefreshToken:  refreshToken,
+		RefreshExpire: refreshExpire,
+	}
+}
+
+// RefreshPairHandler can be used to get a new access token for a refresh token.
+// Reply will be of the form {"token": "TOKEN", "refresh_token": "REFRESH_TOKEN"}.
+// Requires RefreshTokenTimeout.
+func (mw *GfJWTMiddleware) RefreshPairHandler(ctx context.Context) (pair TokenPair) {
+	pair, err := mw.refreshTokenPair(ctx)
+	if err != nil {
+		mw.unauthorized(ctx, revocationStatus(err), err)
+		return TokenPair{}
+	}
+
+	return pair
+}
+
+// TokenPairGenerator method that clients can use to get an access token and a refresh token.
+// Requires RefreshTokenTimeout.
+func (mw *GfJWTMiddleware) TokenPairGenerator(data interface{}) (TokenPair, error) {
+	if !mw.usingRefreshTokens() {
+		return TokenPair{}, ErrRefreshTokensDisabled
+	}
+
+	claims, err := mw.payload(data)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if mw.RefreshTokenRotation {
+		claims[familyClaim] = guid.S()
+	}
+
+	mw.startSession(claims)
+
+	tokenString, expire, err := mw.newAccessToken(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err = mw.registerSession(context.Background(), claims, tokenString, expire); err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, refreshExpire, err := mw.newRefreshToken(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		Token:         tokenString,
+		Expire:        expire.UTC(),
+		RefreshToken:  refreshToken,
+		RefreshExpire: refreshExpire.UTC(),
+	}, nil
+}
+
+// usingRefreshTokens reports whether refresh tokens are issued alongside access tokens.
+func (mw *GfJWTMiddleware) usingRefreshTokens() bool {
+	return mw.RefreshTokenTimeout > 0
+}
+
+// refreshTokenPair checks the refresh token of the request and issues a new access token.
+func (mw *GfJWTMiddleware) refreshTokenPair(ctx context.Context) (TokenPair, error) {
+	if !mw.usingRefreshTokens() {
+		return TokenPair{}, ErrRefreshTokensDisabled
+	}
+
+	r := g.RequestFromCtx(ctx)
+
+	token, err := mw.parseRefreshToken(r)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	if err = mw.validateClaims(MapClaims(claims), refreshTokenType); err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshExpire, ok := mw.parseTimestamp(claims["exp"])
+	if !ok {
+		return TokenPair{}, ErrWrongFormatOfExp
+	}
+
+	if refreshExpire.Before(mw.TimeFunc().Add(-mw.Leeway)) {
+		return TokenPair{}, ErrExpiredToken
+	}
+
+	if err = mw.checkRevocation(ctx, token.Raw, MapClaims(claims)); err != nil {
+		return TokenPair{}, err
+	}
+
+	session, err := mw.lookupSession(ctx, MapClaims(claims), refreshTokenType)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err = mw.checkIdle(ctx, MapClaims(claims), session); err != nil {
+		return TokenPair{}, err
+	}
+
+	// the refresh token is marked as rotated before anything is issued for it, so that
+	// concurrent refreshes with the same token are detected as reuse
+	if mw.RefreshTokenRotation {
+		if err = mw.rotate(ctx, MapClaims(claims), refreshExpire); err != nil {
+			return TokenPair{}, err
+		}
+	}
+
+	tokenString, expire, err := mw.newAccessToken(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err = mw.renewSession(ctx, claims, tokenString, mw.sessionExpiry(expire)); err != nil {
+		return TokenPair{}, err
+	}
+
+	pair := TokenPair{
+		Token:         tokenString,
+		Expire:        expire,
+		RefreshToken:  token.Raw,
+		RefreshExpire: refreshExpire,
+	}
+
+	if mw.RefreshTokenRotation {
+		pair.RefreshToken, pair.RefreshExpire, err = mw.newRefreshToken(claims)
+		if err != nil {
+			return TokenPair{}, err
+		}
+	}
+
+	// set cookie
+	if mw.SendCookie {
+		r.Cookie.SetCookie(mw.CookieName, tokenString, mw.CookieDomain, "/", mw.CookieMaxAge)
+	}
+
+	return pair, nil
+}
+
+// rotate marks the refresh token as rotated, and detects reuse of a token that has been rotated
+// already, which signals theft: the whole family is revoked and RefreshTokenReuseHandler is called.
+// The mark is set atomically, so that only one of concurrent refreshes with the same token succeeds.
+func (mw *GfJWTMiddleware) rotate(ctx context.Context, claims MapClaims, exp time.Time) error {
+	family, _ := claims[familyClaim].(string)
+	jti, _ := claims["jti"].(string)
+	if family == "" || jti == "" {
+		return ErrInvalidToken
+	}
+
+	// the mark lives as long as the token, and at least a second so that it is stored at all
+	duration := exp.Add(mw.Leeway).Sub(mw.TimeFunc())
+	if duration < time.Second {
+		duration = time.Second
+	}
+	first, err := mw.revocationStore.SetIfNotExist(ctx, mw.BlacklistPrefix+"ROTATED:"+jti, true, duration)
+	if err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	if first {
+		return nil
+	}
+
+	if err = mw.setFamilyBlacklist(ctx, family); err != nil {
+		return err
+	}
+	if mw.RefreshTokenReuseHandler != nil {
+		mw.RefreshTokenReuseHandler(ctx, family, claims)
+	}
+	return ErrRefreshTokenReused
+}
+
+// setFamilyBlacklist revokes every token of a family. Tokens of the family live no longer
+// than RefreshTokenTimeout, which bounds the duration of the entry.
+func (mw *GfJWTMiddleware) setFamilyBlacklist(ctx context.Context, family string) error {
+	if err := mw.revocationStore.Set(ctx, mw.BlacklistPrefix+"FAMILY:"+family, true, mw.RefreshTokenTimeout+mw.Leeway); err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	return nil
+}
+
+// inFamilyBlacklist reports whether the family has been revoked.
+func (mw *GfJWTMiddleware) inFamilyBlacklist(ctx context.Context, family string) (bool, error) {
+	return mw.revocationStore.Contains(ctx, mw.BlacklistPrefix+"FAMILY:"+family)
+}
+
+// newRefreshToken signs a refresh token carrying the claims of an access token, valid for RefreshTokenTimeout.
+func (mw *GfJWTMiddleware) newRefreshToken(claims jwt.MapClaims) (string, time.Time, error) {
+	algorithm := mw.SigningAlgorithm
+	if len(mw.RefreshKey) > 0 {
+		algorithm = mw.RefreshSigningAlgorithm
+	}
+
+	token := jwt.New(jwt.GetSigningMethod(algorithm))
+	refreshClaims := token.Claims.(jwt.MapClaims)
+
+	for key, value := range claims {
+		refreshClaims[key] = value
+	}
+
+	now := mw.TimeFunc()
+	expire := now.Add(mw.RefreshTokenTimeout)
+	refreshClaims["exp"] = mw.timestamp(expire)
+	refreshClaims[tokenTypeClaim] = refreshTokenType
+	mw.setRegisteredClaims(refreshClaims, now)
+
+	var (
+		tokenString string
+		err         error
+	)
+	if len(mw.RefreshKey) > 0 {
+		tokenString, err = token.SignedString(mw.RefreshKey)
+	} else {
+		tokenString, err = mw.signedString(token)
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expire, nil
+}
+
+// parseRefreshToken extracts the refresh token from the request with RefreshTokenLookup and verifies it.
+func (mw *GfJWTMiddleware) parseRefreshToken(r *ghttp.Request) (*jwt.Token, error) {
+	token, err := mw.tokenFromRequest(r, mw.RefreshTokenLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mw.RefreshKey) > 0 {
+		return mw.parser().Parse(token, func(t *jwt.Token) (interface{}, error) {
+			if jwt.GetSigningMethod(mw.RefreshSigningAlgorithm) != t.Method {
+				return nil, ErrInvalidSigningAlgorithm
+			}
+			return mw.RefreshKey, nil
+		})
+	}
+
+	return mw.parseTokenString(r.Context(), token)
+}
@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+// newRefreshServer serves RefreshPairHandler at /refresh, and RefreshHandler at /refresh-access.
+func newRefreshServer(t *testing.T, mw *GfJWTMiddleware) *gclient.Client {
+	return newTestServer(t, func(s *ghttp.Server) {
+		s.BindHandler("/refresh", func(r *ghttp.Request) {
+			pair := mw.RefreshPairHandler(r.Context())
+			r.Response.WriteJson(pair)
+		})
+		s.BindHandler("/refresh-access", func(r *ghttp.Request) {
+			token, _ := mw.RefreshHandler(r.Context())
+			r.Response.WriteJson(g.Map{"token": token})
+		})
+	})
+}
+
+// refresh refreshes the refresh token, and returns the new pair, or nil on failure.
+func refresh(c *gclient.Client, refreshToken string) *TokenPair {
+	var pair TokenPair
+	if err := c.GetVar(ctx, "/refresh?refresh_token="+refreshToken).Scan(&pair); err != nil || pair.Token == "" {
+		return nil
+	}
+	return &pair
+}
+
+func TestRefreshTokenRotation_Reuse(t *testing.T) {
+	var reused []string
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		RefreshTokenTimeout:  time.Hour,
+		RefreshTokenRotation: true,
+		RefreshTokenReuseHandler: func(ctx context.Context, family string, claims MapClaims) {
+			reused = append(reused, family)
+		},
+	})
+	c := newRefreshServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		login, err := mw.TokenPairGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		rotated := refresh(c, login.RefreshToken)
+		t.AssertNE(rotated, nil)
+		t.AssertNE(rotated.RefreshToken, login.RefreshToken)
+		t.Assert(len(reused), 0)
+
+		// the rotated token is reused: its family is revoked
+		t.Assert(refresh(c, login.RefreshToken), nil)
+		t.Assert(len(reused), 1)
+		t.Assert(reused[0], unverifiedClaims(login.RefreshToken)[familyClaim])
+		t.Assert(refresh(c, rotated.RefreshToken), nil)
+	})
+}
+
+func TestRefreshTokenRotation_Concurrent(t *testing.T) {
+	var reused int32
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		RefreshTokenTimeout:  time.Hour,
+		RefreshTokenRotation: true,
+		RefreshTokenReuseHandler: func(ctx context.Context, family string, claims MapClaims) {
+			atomic.AddInt32(&reused, 1)
+		},
+	})
+	c := newRefreshServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		login, err := mw.TokenPairGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		var (
+			wg        sync.WaitGroup
+			refreshed int32
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if refresh(c, login.RefreshToken) != nil {
+					atomic.AddInt32(&refreshed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		t.Assert(refreshed, 1)
+		t.Assert(atomic.LoadInt32(&reused) > 0, true)
+	})
+}
+
+func TestRefreshTokenRotation_RefreshHandler(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		RefreshTokenTimeout:  time.Hour,
+		RefreshTokenRotation: true,
+	})
+	c := newRefreshServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		login, err := mw.TokenPairGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		// RefreshHandler can not return the rotated refresh token, so it does not rotate it
+		body := c.GetVar(ctx, "/refresh-access?refresh_token="+login.RefreshToken).Map()
+		t.Assert(body["code"], 500)
+		t.Assert(body["message"], ErrRefreshPairRequired.Error())
+
+		t.AssertNE(refresh(c, login.RefreshToken), nil)
+	})
+}
+
+func TestTokenPair_Types(t *testing.T) {
+	for _, refreshKey := range [][]byte{nil, []byte("refresh key")} {
+		mw := newTestMiddleware(&GfJWTMiddleware{
+			RefreshTokenTimeout: time.Hour,
+			RefreshKey:          refreshKey,
+			Authenticator: func(ctx context.Context) (interface{}, error) {
+				return MapClaims{"identity": g.RequestFromCtx(ctx).Get("username").String()}, nil
+			},
+		})
+		c := newTestServer(t, func(s *ghttp.Server) {
+			s.BindHandler("/login", func(r *ghttp.Request) {
+				token, _ := mw.LoginHandler(r.Context())
+				r.Response.WriteJson(g.Map{"token": token})
+			})
+			s.BindHandler("/login-pair", func(r *ghttp.Request) {
+				r.Response.WriteJson(mw.LoginPairHandler(r.Context()))
+			})
+			s.Group("/", func(group *ghttp.RouterGroup) {
+				group.Middleware(authMiddleware(mw))
+				group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+			})
+		})
+		refreshing := newRefreshServer(t, mw)
+
+		gtest.C(t, func(t *gtest.T) {
+			// LoginHandler can not return the refresh token, so it issues no token
+			body := c.PostVar(ctx, "/login", g.Map{"username": "a"}).Map()
+			t.Assert(body["code"], 500)
+			t.Assert(body["message"], ErrLoginPairRequired.Error())
+
+			var login TokenPair
+			t.AssertNil(c.PostVar(ctx, "/login-pair", g.Map{"username": "a"}).Scan(&login))
+			t.AssertNE(login.Token, "")
+			t.AssertNE(login.RefreshToken, "")
+			t.Assert(unverifiedClaims(login.RefreshToken)[tokenTypeClaim], refreshTokenType)
+			t.Assert(unverifiedClaims(login.Token)[tokenTypeClaim], nil)
+
+			// each token is refused where the other is expected
+			t.Assert(statusOf(c, login.Token, "/hello"), http.StatusOK)
+			t.Assert(statusOf(c, login.RefreshToken, "/hello"), http.StatusUnauthorized)
+			t.Assert(refresh(refreshing, login.Token), nil)
+
+			refreshed := refresh(refreshing, login.RefreshToken)
+			t.AssertNE(refreshed, nil)
+			t.Assert(statusOf(c, refreshed.Token, "/hello"), http.StatusOK)
+			t.Assert(statusOf(c, refreshed.RefreshToken, "/hello"), http.StatusUnauthorized)
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/container/gvar"
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// RevocationStore stores the revocation state of tokens, such as revoked tokens and rotated
+// refresh tokens, until the tokens expire. Each GfJWTMiddleware owns its RevocationStore.
+type RevocationStore interface {
+	// Set stores value under key for duration. Entries with non-positive duration are not stored.
+	Set(ctx context.Context, key string, value interface{}, duration time.Duration) error
+
+	// Get returns the value stored under key, or nil if there is none.
+	Get(ctx context.Context, key string) (*gvar.Var, error)
+
+	// Contains reports whether a value is stored under key.
+	Contains(ctx context.Context, key string) (bool, error)
+
+	// SetIfNotExist stores value under key for duration if no value is stored under key yet,
+	// and reports whether it did. It must be atomic, as it tells concurrent requests apart.
+	SetIfNotExist(ctx context.Context, key string, value interface{}, duration time.Duration) (bool, error)
+}
+
+// RevocationFailurePolicy decides whether a token is accepted when the RevocationStore fails
+// to tell whether it has been revoked.
+type RevocationFailurePolicy int
+
+const (
+	// RevocationFailClosed rejects the request with 503 Service Unavailable.
+	RevocationFailClosed RevocationFailurePolicy = iota
+
+	// RevocationFailOpen accepts the token as not revoked and logs a warning, at most once a minute.
+	RevocationFailOpen
+)
+
+// cacheRevocationStore is a RevocationStore backed by gcache. Its SetIfNotExist is atomic only
+// if the cache is in memory, which atomic tells.
+type cacheRevocationStore struct {
+	cache  *gcache.Cache
+	atomic bool
+}
+
+// NewMemoryRevocationStore creates a RevocationStore in the memory of the process.
+func NewMemoryRevocationStore() RevocationStore {
+	return &cacheRevocationStore{cache: gcache.New(), atomic: true}
+}
+
+// NewCacheRevocationStore creates a RevocationStore backed by a gcache adapter. Its SetIfNotExist
+// is atomic for the memory adapter only, as the other adapters, such as redis, get and set values
+// with separate commands: it cannot be used with RefreshTokenRotation or SlidingWindow, which
+// require an atomic SetIfNotExist. Use NewRedisRevocationStore for redis instead.
+func NewCacheRevocationStore(adapter gcache.Adapter) RevocationStore {
+	_, atomic := adapter.(*gcache.AdapterMemory)
+	return &cacheRevocationStore{cache: gcache.NewWithAdapter(adapter), atomic: atomic}
+}
+
+// redisRevocationStore is a RevocationStore backed by redis, whose SetIfNotExist is a single command.
+type redisRevocationStore struct {
+	cacheRevocationStore
+	redis *gredis.Redis
+}
+
+// NewRedisRevocationStore creates a RevocationStore backed by redis.
+func NewRedisRevocationStore(redis *gredis.Redis) RevocationStore {
+	return &redisRevocationStore{
+		cacheRevocationStore: cacheRevocationStore{cache: gcache.NewWithAdapter(gcache.NewAdapterRedis(redis)), atomic: true},
+		redis:                redis,
+	}
+}
+
+// Set stores value under key for duration.
+func (s *cacheRevocationStore) Set(ctx context.Context, key string, value interface{}, duration time.Duration) error {
+	// gcache keeps entries of zero duration forever
+	if duration <= 0 {
+		return nil
+	}
+	return s.cache.Set(ctx, key, value, duration)
+}
+
+// Get returns the value stored under key.
+func (s *cacheRevocationStore) Get(ctx context.Context, key string) (*gvar.Var, error) {
+	return s.cache.Get(ctx, key)
+}
+
+// Contains reports whether a value is stored under key.
+func (s *cacheRevocationStore) Contains(ctx context.Context, key string) (bool, error) {
+	return s.cache.Contains(ctx, key)
+}
+
+// SetIfNotExist stores value under key for duration if key is not stored yet. The value is set
+// under the lock of the cache, which makes it atomic for the memory cache only.
+func (s *cacheRevocationStore) SetIfNotExist(ctx context.Context, key string, value interface{}, duration time.Duration) (bool, error) {
+	if duration <= 0 {
+		return false, nil
+	}
+	set := false
+	_, err := s.cache.GetOrSetFuncLock(ctx, key, func(ctx context.Context) (interface{}, error) {
+		set = true
+		return value, nil
+	}, duration)
+	return set, err
+}
+
+// SetIfNotExist stores value under key for duration if key is not stored yet, with SET NX.
+func (s *redisRevocationStore) SetIfNotExist(ctx context.Context, key string, value interface{}, duration time.Duration) (bool, error) {
+	if duration <= 0 {
+		return false, nil
+	}
+	v, err := s.redis.Do(ctx, "SET", key, value, "PX", duration.Milliseconds(), "NX")
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNil(), nil
+}
+
+// atomicRevocationStore reports whether the SetIfNotExist of store is atomic. Stores other than
+// those of NewCacheRevocationStore are required to be.
+func atomicRevocationStore(store RevocationStore) bool {
+	if s, ok := store.(*cacheRevocationStore); ok {
+		return s.atomic
+	}
+	return true
+}
+
+// breakerRevocationStore is a circuit breaker in front of a RevocationStore. After threshold
+// consecutive failures, the store is not called for cooldown, so that requests do not wait
+// on a dead backend. After cooldown, a single call probes the store while the others still
+// fail, and closes the circuit if it succeeds. The breaker runs on the wall clock, as the
+// backend fails in real time, whatever TimeFunc says.
+type breakerRevocationStore struct {
+	store     RevocationStore
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// newBreakerRevocationStore puts the RevocationStore of the middleware behind a circuit breaker.
+func newBreakerRevocationStore(mw *GfJWTMiddleware) *breakerRevocationStore {
+	return &breakerRevocationStore{
+		store:     mw.RevocationStore,
+		threshold: mw.RevocationBreakerThreshold,
+		cooldown:  mw.RevocationBreakerCooldown,
+		now:       time.Now,
+	}
+}
+
+// Set stores value under key for duration, unless the circuit is open.
+func (s *breakerRevocationStore) Set(ctx context.Context, key string, value interface{}, duration time.Duration) error {
+	return s.call(ctx, func() error {
+		return s.store.Set(ctx, key, value, duration)
+	})
+}
+
+// Get returns the value stored under key, unless the circuit is open.
+func (s *breakerRevocationStore) Get(ctx context.Context, key string) (v *gvar.Var, err error) {
+	err = s.call(ctx, func() error {
+		v, err = s.store.Get(ctx, key)
+		return err
+	})
+	return v, err
+}
+
+// Contains reports whether a value is stored under key, unless the circuit is open.
+func (s *breakerRevocationStore) Contains(ctx context.Context, key string) (in bool, err error) {
+	err = s.call(ctx, func() error {
+		in, err = s.store.Contains(ctx, key)
+		return err
+	})
+	return in, err
+}
+
+// SetIfNotExist stores value under key for duration if key is not stored yet, unless the circuit is open.
+func (s *breakerRevocationStore) SetIfNotExist(ctx context.Context, key string, value interface{}, duration time.Duration) (set bool, err error) {
+	err = s.call(ctx, func() error {
+		set, err = s.store.SetIfNotExist(ctx, key, value, duration)
+		return err
+	})
+	return set, err
+}
+
+// call calls fn if the circuit is closed, or as the probe of a circuit whose cooldown is over,
+// and opens the circuit after threshold consecutive failures.
+func (s *breakerRevocationStore) call(ctx context.Context, fn func() error) error {
+	s.mu.Lock()
+	probe := false
+	if s.failures >= s.threshold {
+		if s.probing || s.now().Before(s.openUntil) {
+			s.mu.Unlock()
+			return ErrRevocationBackendUnavailable
+		}
+		s.probing, probe = true, true
+	}
+	s.mu.Unlock()
+
+	err := fn()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if probe {
+		s.probing = false
+	}
+	if err == nil {
+		s.failures = 0
+		return nil
+	}
+	s.failures++
+	if s.failures >= s.threshold {
+		s.openUntil = s.now().Add(s.cooldown)
+		if s.failures == s.threshold {
+			g.Log().Warningf(ctx, "jwt: revocation store failed %d times, not calling it for %s", s.failures, s.cooldown)
+		}
+	}
+	return err
+}
+
+// RevokeTokenID revokes the token with the given jti, which need not be at hand.
+func (mw *GfJWTMiddleware) RevokeTokenID(ctx context.Context, jti string) error {
+	if err := mw.revocationStore.Set(ctx, mw.BlacklistPrefix+"JTI:"+jti, true, mw.maxTokenLifetime()); err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	return nil
+}
+
+// RevokeAllForIdentity revokes every token issued for identity until now, e.g. after a password change.
+// Tokens issued afterwards are not affected, nor are those issued within the same second, as "iat"
+// has a resolution of a second.
+func (mw *GfJWTMiddleware) RevokeAllForIdentity(ctx context.Context, identity interface{}) error {
+	if err := mw.revocationStore.Set(ctx, mw.identityRevocationKey(identity), mw.TimeFunc().Unix(), mw.maxTokenLifetime()); err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	return nil
+}
+
+// checkRevocation rejects tokens that have been revoked by jti or raw token, by identity, or by family.
+// Failures of the RevocationStore are handled according to RevocationFailurePolicy.
+func (mw *GfJWTMiddleware) checkRevocation(ctx context.Context, token string, claims MapClaims) error {
+	in, err := mw.inBlacklist(ctx, token, claims)
+	if err != nil {
+		if err = mw.lookupFailure(ctx, err); err != nil {
+			return err
+		}
+	} else if in {
+		return ErrRevokedToken
+	}
+
+	if identity, ok := claims[mw.IdentityKey]; ok {
+		v, err := mw.revocationStore.Get(ctx, mw.identityRevocationKey(identity))
+		if err != nil {
+			if err = mw.lookupFailure(ctx, err); err != nil {
+				return err
+			}
+		} else if v != nil && !v.IsNil() && !mw.issuedAfter(claims, v.Int64()) {
+			return ErrRevokedToken
+		}
+	}
+
+	if family, ok := claims[familyClaim].(string); ok && mw.RefreshTokenRotation {
+		revoked, err := mw.inFamilyBlacklist(ctx, family)
+		if err != nil {
+			if err = mw.lookupFailure(ctx, err); err != nil {
+				return err
+			}
+		} else if revoked {
+			return ErrRevokedToken
+		}
+	}
+
+	return nil
+}
+
+// revocationFailure reports a failure of the RevocationStore to RevocationFailureHandler,
+// and returns ErrRevocationBackendUnavailable.
+func (mw *GfJWTMiddleware) revocationFailure(ctx context.Context, err error) error {
+	if mw.RevocationFailureHandler != nil {
+		mw.RevocationFailureHandler(ctx, err)
+	}
+	return ErrRevocationBackendUnavailable
+}
+
+// lookupFailure reports a failed lookup in the RevocationStore. It returns nil if
+// RevocationFailurePolicy accepts the token without the answer of the store.
+func (mw *GfJWTMiddleware) lookupFailure(ctx context.Context, err error) error {
+	if mw.RevocationFailurePolicy != RevocationFailOpen {
+		return mw.revocationFailure(ctx, err)
+	}
+
+	if logged, suppressed := mw.failOpenLog.allow(time.Now()); logged {
+		g.Log().Warningf(ctx, "jwt: accepting token without revocation check: %v (%d more since the last warning)", err, suppressed)
+	}
+	_ = mw.revocationFailure(ctx, err)
+	return nil
+}
+
+// failOpenLogInterval is the minimum interval between two warnings about tokens accepted
+// without revocation check, which would otherwise be logged for every request of an outage.
+const failOpenLogInterval = time.Minute
+
+// rateLimitedLog lets a message be logged once per failOpenLogInterval.
+type rateLimitedLog struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// allow reports whether the message may be logged at now, and how many times it was not
+// since it was last logged.
+func (l *rateLimitedLog) allow(now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() && now.Sub(l.last) < failOpenLogInterval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.last, l.suppressed = now, 0
+	return true, suppressed
+}
+
+// revocationStatus returns the HTTP status of a request failing with err: 503 if the
+// RevocationStore is unavailable, 401 otherwise.
+func revocationStatus(err error) int {
+	if errors.Is(err, ErrRevocationBackendUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusUnauthorized
+}
+
+// blacklistKey returns the revocation key of a token, by its jti if it has one.
+// Otherwise, the raw token is hashed, with the goal of MD5 being to reduce the key length.
+func (mw *GfJWTMiddleware) blacklistKey(token string, claims MapClaims) (string, error) {
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		return mw.BlacklistPrefix + "JTI:" + jti, nil
+	}
+
+	hash, err := gmd5.EncryptString(token)
+	if err != nil {
+		return "", err
+	}
+	return mw.BlacklistPrefix + hash, nil
+}
+
+// identityRevocationKey returns the key storing the time at which every token of identity was revoked.
+func (mw *GfJWTMiddleware) identityRevocationKey(identity interface{}) string {
+	return mw.BlacklistPrefix + "IDENTITY:" + gconv.String(identity)
+}
+
+// issuedAfter reports whether the token was issued at or after revokedAt, in seconds since the
+// epoch, the resolution of "iat": tokens issued within the second of a revocation are not revoked,
+// so that logins right after it are not. Tokens without "iat" fall back to "orig_iat".
+func (mw *GfJWTMiddleware) issuedAfter(claims MapClaims, revokedAt int64) bool {
+	if iat, ok := numericDate(claims["iat"]); ok {
+		return iat >= revokedAt
+	}
+	if origIat, ok := mw.parseTimestamp(claims["orig_iat"]); ok {
+		return origIat.Unix() >= revokedAt
+	}
+	return false
+}
+
+// revocationDuration returns how long the revocation of a token expiring at exp is kept: until
+// the token can no longer be refreshed within MaxRefresh, nor be accepted within Leeway.
+func (mw *GfJWTMiddleware) revocationDuration(exp time.Time) time.Duration {
+	return exp.Add(mw.MaxRefresh).Add(mw.Leeway).Sub(mw.TimeFunc())
+}
+
+// maxTokenLifetime returns the longest time a token issued now stays usable, which bounds
+// the duration of revocation entries.
+func (mw *GfJWTMiddleware) maxTokenLifetime() time.Duration {
+	lifetime := mw.Timeout + mw.MaxRefresh
+	if mw.RefreshTokenTimeout > lifetime {
+		lifetime = mw.RefreshTokenTimeout
+	}
+	return lifetime + mw.Leeway
+}
@@ -0,0 +1,364 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/container/gvar"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestRevokeAllForIdentity(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{TimeFunc: clock.Now})
+
+	gtest.C(t, func(t *gtest.T) {
+		before, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		other, _, _ := mw.TokenGenerator(MapClaims{"identity": "b"})
+
+		clock.Add(time.Second)
+		t.AssertNil(mw.RevokeAllForIdentity(ctx, "a"))
+
+		// tokens issued within the same second as the revocation are not revoked
+		after, _, _ := mw.TokenGenerator(MapClaims{"identity": "a"})
+
+		t.Assert(mw.checkRevocation(ctx, before, unverifiedClaims(before)), ErrRevokedToken)
+		t.AssertNil(mw.checkRevocation(ctx, after, unverifiedClaims(after)))
+		t.AssertNil(mw.checkRevocation(ctx, other, unverifiedClaims(other)))
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, _ := mw.TokenGenerator(MapClaims{"identity": "c"})
+		claims := unverifiedClaims(token)
+
+		iat, _ := numericDate(claims["iat"])
+		t.Assert(mw.issuedAfter(claims, iat-1), true)
+		t.Assert(mw.issuedAfter(claims, iat), true)
+		t.Assert(mw.issuedAfter(claims, iat+1), false)
+
+		// tokens without "iat" fall back to "orig_iat"
+		delete(claims, "iat")
+		t.Assert(mw.issuedAfter(claims, iat), true)
+		t.Assert(mw.issuedAfter(claims, iat+1), false)
+		delete(claims, "orig_iat")
+		t.Assert(mw.issuedAfter(claims, iat-1), false)
+	})
+}
+
+// downRevocationStore is a RevocationStore that fails while down, counting the calls.
+type downRevocationStore struct {
+	RevocationStore
+	down  int32
+	calls int32
+
+	// gate, if set, holds the calls until it is closed
+	gate chan struct{}
+}
+
+func newDownRevocationStore() *downRevocationStore {
+	return &downRevocationStore{RevocationStore: NewMemoryRevocationStore(), down: 1}
+}
+
+func (s *downRevocationStore) call() error {
+	atomic.AddInt32(&s.calls, 1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	if atomic.LoadInt32(&s.down) == 1 {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (s *downRevocationStore) Set(ctx context.Context, key string, value interface{}, duration time.Duration) error {
+	if err := s.call(); err != nil {
+		return err
+	}
+	return s.RevocationStore.Set(ctx, key, value, duration)
+}
+
+func (s *downRevocationStore) Get(ctx context.Context, key string) (*gvar.Var, error) {
+	if err := s.call(); err != nil {
+		return nil, err
+	}
+	return s.RevocationStore.Get(ctx, key)
+}
+
+func (s *downRevocationStore) Contains(ctx context.Context, key string) (bool, error) {
+	if err := s.call(); err != nil {
+		return false, err
+	}
+	return s.RevocationStore.Contains(ctx, key)
+}
+
+func (s *downRevocationStore) SetIfNotExist(ctx context.Context, key string, value interface{}, duration time.Duration) (bool, error) {
+	if err := s.call(); err != nil {
+		return false, err
+	}
+	return s.RevocationStore.SetIfNotExist(ctx, key, value, duration)
+}
+
+func TestRevocationFailurePolicy(t *testing.T) {
+	var failures int32
+	closed := newTestMiddleware(&GfJWTMiddleware{RevocationStore: newDownRevocationStore()})
+	open := newTestMiddleware(&GfJWTMiddleware{
+		RevocationStore:         newDownRevocationStore(),
+		RevocationFailurePolicy: RevocationFailOpen,
+		RevocationFailureHandler: func(ctx context.Context, err error) {
+			atomic.AddInt32(&failures, 1)
+		},
+	})
+
+	status := make(map[*GfJWTMiddleware]func(token string) int)
+	for _, mw := range []*GfJWTMiddleware{closed, open} {
+		c := newTestServer(t, func(s *ghttp.Server) {
+			s.Group("/", func(group *ghttp.RouterGroup) {
+				group.Middleware(authMiddleware(mw))
+				group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+			})
+		})
+		status[mw] = func(token string) int {
+			resp, err := bearer(c, token).Get(ctx, "/hello")
+			if err != nil {
+				return 0
+			}
+			defer resp.Close()
+			return resp.StatusCode
+		}
+	}
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := closed.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		t.Assert(errors.Is(closed.checkRevocation(ctx, token, unverifiedClaims(token)), ErrRevocationBackendUnavailable), true)
+		t.Assert(status[closed](token), http.StatusServiceUnavailable)
+
+		t.AssertNil(open.checkRevocation(ctx, token, unverifiedClaims(token)))
+		t.Assert(status[open](token), http.StatusOK)
+		t.Assert(atomic.LoadInt32(&failures) > 0, true)
+
+		// revocations are not dropped silently
+		t.Assert(errors.Is(open.RevokeTokenID(ctx, "jti"), ErrRevocationBackendUnavailable), true)
+	})
+}
+
+func TestRevocationBreaker(t *testing.T) {
+	clock := newTestClock()
+	store := newDownRevocationStore()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		RevocationStore:            store,
+		RevocationBreakerThreshold: 3,
+		RevocationBreakerCooldown:  time.Minute,
+	})
+	// the breaker runs on the wall clock, which the test stands in for
+	mw.revocationStore.(*breakerRevocationStore).now = clock.Now
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		claims := unverifiedClaims(token)
+
+		for i := 0; i < 3; i++ {
+			_, _ = mw.revocationStore.Get(ctx, "key")
+		}
+		t.Assert(atomic.LoadInt32(&store.calls), 3)
+
+		// the circuit is open: the store is not called for the cooldown
+		t.Assert(errors.Is(mw.checkRevocation(ctx, token, claims), ErrRevocationBackendUnavailable), true)
+		t.Assert(atomic.LoadInt32(&store.calls), 3)
+
+		// and is called again after it, closing the circuit once the store answers
+		atomic.StoreInt32(&store.down, 0)
+		clock.Add(time.Minute)
+		t.AssertNil(mw.checkRevocation(ctx, token, claims))
+		t.Assert(atomic.LoadInt32(&store.calls) > 3, true)
+
+		atomic.StoreInt32(&store.down, 1)
+		calls := atomic.LoadInt32(&store.calls)
+		_, _ = mw.revocationStore.Get(ctx, "key")
+		_, _ = mw.revocationStore.Get(ctx, "key")
+		t.Assert(atomic.LoadInt32(&store.calls), calls+2)
+	})
+}
+
+func TestRevocationBreaker_Probe(t *testing.T) {
+	clock := newTestClock()
+	store := newDownRevocationStore()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		RevocationStore:            store,
+		RevocationBreakerThreshold: 1,
+		RevocationBreakerCooldown:  time.Minute,
+		// TimeFunc does not drive the breaker
+		TimeFunc: func() time.Time { return time.Unix(0, 0) },
+	})
+	breaker := mw.revocationStore.(*breakerRevocationStore)
+	breaker.now = clock.Now
+
+	gtest.C(t, func(t *gtest.T) {
+		_, _ = breaker.Get(ctx, "key")
+		t.Assert(atomic.LoadInt32(&store.calls), 1)
+		clock.Add(time.Minute)
+
+		// a single call probes the store after the cooldown, the others fail meanwhile
+		store.gate = make(chan struct{})
+		probed := make(chan error)
+		go func() {
+			_, err := breaker.Get(ctx, "key")
+			probed <- err
+		}()
+		for atomic.LoadInt32(&store.calls) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		for i := 0; i < 5; i++ {
+			_, err := breaker.Get(ctx, "key")
+			t.Assert(err, ErrRevocationBackendUnavailable)
+		}
+		t.Assert(atomic.LoadInt32(&store.calls), 2)
+
+		// a failed probe opens the circuit for another cooldown
+		close(store.gate)
+		t.AssertNE(<-probed, nil)
+		store.gate = nil
+		_, err := breaker.Get(ctx, "key")
+		t.Assert(err, ErrRevocationBackendUnavailable)
+		t.Assert(atomic.LoadInt32(&store.calls), 2)
+
+		// and a successful one closes it
+		clock.Add(time.Minute)
+		atomic.StoreInt32(&store.down, 0)
+		_, err = breaker.Get(ctx, "key")
+		t.AssertNil(err)
+		_, err = breaker.Get(ctx, "key")
+		t.AssertNil(err)
+		t.Assert(atomic.LoadInt32(&store.calls), 4)
+	})
+}
+
+func TestRateLimitedLog(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			l   rateLimitedLog
+			now = time.Unix(0, 0)
+		)
+		logged, _ := l.allow(now)
+		t.Assert(logged, true)
+
+		for i := 0; i < 3; i++ {
+			logged, _ = l.allow(now.Add(time.Duration(i) * time.Second))
+			t.Assert(logged, false)
+		}
+
+		logged, suppressed := l.allow(now.Add(failOpenLogInterval))
+		t.Assert(logged, true)
+		t.Assert(suppressed, 3)
+		logged, _ = l.allow(now.Add(failOpenLogInterval + time.Second))
+		t.Assert(logged, false)
+	})
+}
+
+// durationRevocationStore records the duration of the entries it stores.
+type durationRevocationStore struct {
+	RevocationStore
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+func (s *durationRevocationStore) Set(ctx context.Context, key string, value interface{}, duration time.Duration) error {
+	s.mu.Lock()
+	s.durations[key] = duration
+	s.mu.Unlock()
+	return s.RevocationStore.Set(ctx, key, value, duration)
+}
+
+func (s *durationRevocationStore) SetIfNotExist(ctx context.Context, key string, value interface{}, duration time.Duration) (bool, error) {
+	s.mu.Lock()
+	s.durations[key] = duration
+	s.mu.Unlock()
+	return s.RevocationStore.SetIfNotExist(ctx, key, value, duration)
+}
+
+func TestRevocationDuration(t *testing.T) {
+	store := &durationRevocationStore{RevocationStore: NewMemoryRevocationStore(), durations: map[string]time.Duration{}}
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		Timeout:         10 * time.Minute,
+		MaxRefresh:      time.Hour,
+		Leeway:          5 * time.Minute,
+		RevocationStore: store,
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, expire, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		claims := unverifiedClaims(token)
+		t.AssertNil(mw.setBlacklist(ctx, token, jwt.MapClaims(claims)))
+
+		// the token is revoked as long as it may be refreshed, leeway included
+		key, _ := mw.blacklistKey(token, claims)
+		// exp is in milliseconds
+		t.Assert(store.durations[key] >= time.Until(expire.Add(time.Hour+5*time.Minute-time.Millisecond)), true)
+	})
+}
+
+func TestRevocationStoreAtomicity(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		redisAdapter := gcache.NewAdapterRedis(nil)
+
+		// rotations and reissues need an atomic SetIfNotExist, which the redis adapter lacks
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{CacheAdapter: redisAdapter, RefreshTokenRotation: true}), ErrNonAtomicRevocationStore)
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{CacheAdapter: redisAdapter, SlidingWindow: time.Minute}), ErrNonAtomicRevocationStore)
+		t.Assert(newMiddlewareError(&GfJWTMiddleware{RevocationStore: NewCacheRevocationStore(redisAdapter), SlidingWindow: time.Minute}), ErrNonAtomicRevocationStore)
+
+		t.AssertNil(newMiddlewareError(&GfJWTMiddleware{CacheAdapter: redisAdapter}))
+		t.AssertNil(newMiddlewareError(&GfJWTMiddleware{CacheAdapter: gcache.NewAdapterMemory(), RefreshTokenRotation: true}))
+		t.AssertNil(newMiddlewareError(&GfJWTMiddleware{RevocationStore: NewRedisRevocationStore(nil), RefreshTokenRotation: true}))
+	})
+}
+
+func TestRevocationStore_Isolation(t *testing.T) {
+	admin := newTestMiddleware(&GfJWTMiddleware{Realm: "admin"})
+	user := newTestMiddleware(&GfJWTMiddleware{Realm: "user", CacheAdapter: gcache.NewAdapterMemory()})
+	other := newTestMiddleware(&GfJWTMiddleware{Realm: "other"})
+	adminServer := newSessionServer(t, admin)
+	userServer := newSessionServer(t, user)
+
+	gtest.C(t, func(t *gtest.T) {
+		// every middleware owns its store, including the default one
+		t.Assert(admin.RevocationStore != user.RevocationStore, true)
+		t.Assert(admin.RevocationStore != other.RevocationStore, true)
+
+		// the instances share their key, so that their tokens are valid in both
+		token, _, err := admin.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(statusOf(adminServer, token, "/hello"), http.StatusOK)
+		t.Assert(statusOf(userServer, token, "/hello"), http.StatusOK)
+
+		// but the logout of one instance is not seen by the other
+		t.Assert(statusOf(adminServer, token, "/logout"), http.StatusOK)
+		t.Assert(statusOf(adminServer, token, "/hello"), http.StatusUnauthorized)
+		t.Assert(statusOf(userServer, token, "/hello"), http.StatusOK)
+
+		token, _, err = user.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(statusOf(userServer, token, "/logout"), http.StatusOK)
+		t.Assert(statusOf(userServer, token, "/hello"), http.StatusUnauthorized)
+		t.Assert(statusOf(adminServer, token, "/hello"), http.StatusOK)
+
+		// nor are their revocations of identities
+		t.AssertNil(other.RevokeAllForIdentity(ctx, "b"))
+		for mw, revoked := range map[*GfJWTMiddleware]bool{other: true, admin: false, user: false} {
+			in, err := mw.RevocationStore.Contains(ctx, mw.identityRevocationKey("b"))
+			t.AssertNil(err)
+			t.Assert(in, revoked)
+		}
+	})
+}
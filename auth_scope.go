@@ -0,0 +1,104 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// scopeError is ErrInsufficientScope along with the scopes required by the resource.
+type scopeError struct {
+	scopes []string
+}
+
+// Error returns the message of ErrInsufficientScope.
+func (e *scopeError) Error() string {
+	return ErrInsufficientScope.Error()
+}
+
+// Unwrap returns ErrInsufficientScope.
+func (e *scopeError) Unwrap() error {
+	return ErrInsufficientScope
+}
+
+// GetRoles help to get the roles of the identity, parsed from the RolesKey claim
+func (mw *GfJWTMiddleware) GetRoles(ctx context.Context) []string {
+	r := g.RequestFromCtx(ctx)
+	return r.GetParam(RolesParamKey).Strings()
+}
+
+// GetScopes help to get the scopes of the token, parsed from the ScopesKey claim
+func (mw *GfJWTMiddleware) GetScopes(ctx context.Context) []string {
+	r := g.RequestFromCtx(ctx)
+	return r.GetParam(ScopesParamKey).Strings()
+}
+
+// RequireScopes returns a middleware for routes that need all of the given scopes. Requests whose
+// token lacks any of them are rejected with 403 and an error wrapping ErrInsufficientScope, the others go on to the
+// next handler. Shall be put after the GfJWTMiddleware.
+func (mw *GfJWTMiddleware) RequireScopes(scopes ...string) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		if !containsAll(mw.GetScopes(r.Context()), scopes) {
+			mw.unauthorized(r.Context(), http.StatusForbidden, &scopeError{scopes: scopes})
+			return
+		}
+		r.Middleware.Next()
+	}
+}
+
+// RequireAnyRole returns a middleware for routes that need any of the given roles. Requests whose
+// identity has none of them are rejected with 403 and ErrForbidden, the others go on to the
+// next handler. Shall be put after the GfJWTMiddleware.
+func (mw *GfJWTMiddleware) RequireAnyRole(roles ...string) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		if !containsAny(mw.GetRoles(r.Context()), roles) {
+			mw.unauthorized(r.Context(), http.StatusForbidden, ErrForbidden)
+			return
+		}
+		r.Middleware.Next()
+	}
+}
+
+// claimValues converts a claim holding a space-delimited string or an array of strings to a list.
+func claimValues(v interface{}) []string {
+	switch values := v.(type) {
+	case string:
+		return strings.Fields(values)
+	case []string:
+		return values
+	case []interface{}:
+		list := make([]string, 0, len(values))
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// containsAny reports whether values contains any of wanted.
+func containsAny(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		for _, v := range values {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsAll reports whether values contains all of wanted.
+func containsAll(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		if !containsAny(values, []string{w}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package jwt
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+func TestClaimValues(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(claimValues("orders:read"), []string{"orders:read"})
+		t.Assert(claimValues(" orders:read  orders:write "), []string{"orders:read", "orders:write"})
+		t.Assert(claimValues([]string{"admin", "ops"}), []string{"admin", "ops"})
+
+		// arrays decoded from JSON hold interfaces, of which only strings are kept
+		t.Assert(claimValues([]interface{}{"admin", 1, "ops"}), []string{"admin", "ops"})
+
+		t.Assert(len(claimValues("")), 0)
+		t.Assert(len(claimValues(nil)), 0)
+		t.Assert(len(claimValues(42)), 0)
+	})
+}
+
+// newScopeServer serves /orders, which requires the orders:read and orders:write scopes, and
+// /admin, which requires the admin or ops role.
+func newScopeServer(t *testing.T, mw *GfJWTMiddleware) *gclient.Client {
+	return newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.Group("/orders", func(group *ghttp.RouterGroup) {
+				group.Middleware(mw.RequireScopes("orders:read", "orders:write"))
+				group.ALL("/", func(r *ghttp.Request) {
+					r.Response.Write(strings.Join(mw.GetScopes(r.Context()), " "))
+				})
+			})
+			group.Group("/admin", func(group *ghttp.RouterGroup) {
+				group.Middleware(mw.RequireAnyRole("admin", "ops"))
+				group.ALL("/", func(r *ghttp.Request) {
+					r.Response.Write(strings.Join(mw.GetRoles(r.Context()), " "))
+				})
+			})
+		})
+	})
+}
+
+func TestRequireScopes(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{})
+	c := newScopeServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		for _, test := range []struct {
+			scope  interface{}
+			status int
+		}{
+			{"orders:read orders:write", http.StatusOK},
+			{"orders:write profile orders:read", http.StatusOK},
+			{[]string{"orders:read", "orders:write"}, http.StatusOK},
+			{"orders:read", http.StatusForbidden},
+			{[]string{"orders:read orders:write"}, http.StatusForbidden},
+			{"orders:read,orders:write", http.StatusForbidden},
+			{"", http.StatusForbidden},
+		} {
+			token, _, err := mw.TokenGenerator(MapClaims{"identity": "a", "scope": test.scope})
+			t.AssertNil(err)
+			t.Assert(statusOf(c, token, "/orders"), test.status)
+		}
+
+		// tokens without the claim have no scope
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(statusOf(c, token, "/orders"), http.StatusForbidden)
+	})
+
+	// the required scopes are challenged
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a", "scope": "orders:read"})
+		t.AssertNil(err)
+		resp, err := bearer(c, token).Get(ctx, "/orders")
+		t.AssertNil(err)
+		defer resp.Close()
+
+		t.Assert(resp.StatusCode, http.StatusForbidden)
+		challenge := resp.Header.Get("WWW-Authenticate")
+		t.Assert(strings.Contains(challenge, `error="insufficient_scope"`), true)
+		t.Assert(strings.Contains(challenge, `scope="orders:read orders:write"`), true)
+	})
+}
+
+func TestRequireAnyRole(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{})
+	c := newScopeServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		for _, test := range []struct {
+			roles  interface{}
+			status int
+		}{
+			{"admin", http.StatusOK},
+			{"user ops", http.StatusOK},
+			{[]string{"user", "admin"}, http.StatusOK},
+			{"user", http.StatusForbidden},
+			{[]string{"administrator"}, http.StatusForbidden},
+			{"", http.StatusForbidden},
+		} {
+			token, _, err := mw.TokenGenerator(MapClaims{"identity": "a", "roles": test.roles})
+			t.AssertNil(err)
+			t.Assert(statusOf(c, token, "/admin"), test.status)
+		}
+	})
+}
+
+func TestRolesKey_ScopesKey(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{RolesKey: "groups", ScopesKey: "permissions"})
+	c := newScopeServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{
+			"identity":    "a",
+			"groups":      []string{"ops"},
+			"permissions": "orders:read orders:write",
+		})
+		t.AssertNil(err)
+		t.Assert(bearer(c, token).GetContent(ctx, "/admin"), "ops")
+		t.Assert(bearer(c, token).GetContent(ctx, "/orders"), "orders:read orders:write")
+
+		// the default claims are not read
+		token, _, err = mw.TokenGenerator(MapClaims{
+			"identity": "a",
+			"roles":    "admin",
+			"scope":    "orders:read orders:write",
+		})
+		t.AssertNil(err)
+		t.Assert(statusOf(c, token, "/admin"), http.StatusForbidden)
+		t.Assert(statusOf(c, token, "/orders"), http.StatusForbidden)
+	})
+}
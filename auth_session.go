@@ -0,0 +1,724 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/guid"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// sessionClaim is the claim that carries the session ID, shared by every token issued
+// for one login when a TokenStore or an IdleTimeout is set.
+const sessionClaim = "sid"
+
+// tokenStoreCachePrefix is the prefix of the cache keys of TokenStores backed by gcache.
+const tokenStoreCachePrefix = "JWT:SESSION:"
+
+// tokenStoreIdentityPrefix is the prefix of the cache keys listing the sessions of an identity.
+const tokenStoreIdentityPrefix = tokenStoreCachePrefix + "IDENTITY:"
+
+// SessionLimitPolicy decides what happens to a login beyond MaxSessions.
+type SessionLimitPolicy int
+
+const (
+	// SessionLimitReject rejects the login with ErrTooManySessions.
+	SessionLimitReject SessionLimitPolicy = iota
+
+	// SessionLimitEvictOldest signs out the oldest sessions of the identity to make room for the login.
+	SessionLimitEvictOldest
+)
+
+// Session is a login registered in a TokenStore, along with its current access token.
+type Session struct {
+	// ID of the session, the "sid" claim of its tokens
+	ID string `json:"id" orm:"id"`
+
+	// TokenID is the jti of the access token last issued for the session
+	TokenID string `json:"token_id" orm:"token_id"`
+
+	// PreviousTokenID is the jti of the access token replaced by TokenID
+	PreviousTokenID string `json:"previous_token_id" orm:"previous_token_id"`
+
+	// Time TokenID replaced PreviousTokenID
+	RotatedAt time.Time `json:"rotated_at" orm:"rotated_at"`
+
+	// Identity the session belongs to
+	Identity string `json:"identity" orm:"identity"`
+
+	// Device the session was started from
+	Device string `json:"device" orm:"device"`
+
+	// IP address the session was last used from
+	IP string `json:"ip" orm:"ip"`
+
+	// User agent the session was last used with
+	UserAgent string `json:"user_agent" orm:"user_agent"`
+
+	// Time the session was started
+	IssuedAt time.Time `json:"issued_at" orm:"issued_at"`
+
+	// Time the session was last used, recorded at most once per SessionActivityInterval
+	LastSeen time.Time `json:"last_seen" orm:"last_seen"`
+
+	// Time after which no token of the session can be used or refreshed
+	ExpiresAt time.Time `json:"expires_at" orm:"expires_at"`
+}
+
+// TokenStore is the allow-list of sessions. When a TokenStore is set, tokens are only accepted
+// while their session is stored, and only the last access token issued for a session is accepted.
+type TokenStore interface {
+	// Set stores session for duration, replacing the session with the same ID.
+	Set(ctx context.Context, session *Session, duration time.Duration) error
+
+	// Get returns the session with the given ID, or nil if there is none.
+	Get(ctx context.Context, id string) (*Session, error)
+
+	// Update changes the stored session with the given ID with fn, and stores it again for the
+	// duration fn returns, or leaves it unchanged if the duration is zero. Sessions that are not
+	// stored are not created again, so that revoked sessions stay revoked: Update reports whether
+	// the session was found. Updates must not be lost to concurrent writes of the session.
+	Update(ctx context.Context, id string, fn func(session *Session) time.Duration) (bool, error)
+
+	// Delete removes the session with the given ID.
+	Delete(ctx context.Context, id string) error
+
+	// List returns the sessions of identity, oldest first.
+	List(ctx context.Context, identity string) ([]*Session, error)
+}
+
+// cacheTokenStore is a TokenStore backed by gcache. Writes of sessions are serialized by a lock
+// of the process, which makes Update atomic for the memory cache only. The IDs of the sessions
+// of an identity are listed under a key of their own, which is updated by read-modify-write:
+// sessions of an identity started at the same time on several processes may be missing from the list.
+type cacheTokenStore struct {
+	cache *gcache.Cache
+	mu    sync.Mutex
+}
+
+// redisTokenStore is a TokenStore backed by redis, whose Update is a compare-and-set.
+type redisTokenStore struct {
+	cacheTokenStore
+	redis *gredis.Redis
+}
+
+// redisCompareAndSet is the script replacing the value of KEYS[1] with ARGV[2] for ARGV[3]
+// milliseconds, if its value is still ARGV[1].
+const redisCompareAndSet = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false`
+
+// sessionUpdateAttempts is the number of times the redis and database TokenStores try to
+// update a session that keeps being changed concurrently.
+const sessionUpdateAttempts = 8
+
+// NewMemoryTokenStore creates a TokenStore in the memory of the process.
+func NewMemoryTokenStore() TokenStore {
+	return &cacheTokenStore{cache: gcache.New()}
+}
+
+// NewCacheTokenStore creates a TokenStore backed by a gcache adapter. Sessions are updated
+// atomically only within the process: use NewRedisTokenStore for redis shared by several processes.
+func NewCacheTokenStore(adapter gcache.Adapter) TokenStore {
+	return &cacheTokenStore{cache: gcache.NewWithAdapter(adapter)}
+}
+
+// NewRedisTokenStore creates a TokenStore backed by redis.
+func NewRedisTokenStore(redis *gredis.Redis) TokenStore {
+	return &redisTokenStore{
+		cacheTokenStore: cacheTokenStore{cache: gcache.NewWithAdapter(gcache.NewAdapterRedis(redis))},
+		redis:           redis,
+	}
+}
+
+// Set stores session for duration.
+func (s *cacheTokenStore) Set(ctx context.Context, session *Session, duration time.Duration) error {
+	// gcache keeps entries of zero duration forever
+	if duration <= 0 {
+		return nil
+	}
+	// sessions are stored encoded, so that they are not shared with the caller in memory
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = s.cache.Set(ctx, tokenStoreCachePrefix+session.ID, string(data), duration); err != nil {
+		return err
+	}
+
+	ids, err := s.identitySessions(ctx, session.Identity)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == session.ID {
+			return nil
+		}
+	}
+
+	// the list lives as long as the longest session of the identity
+	if expire, err := s.cache.GetExpire(ctx, tokenStoreIdentityPrefix+session.Identity); err == nil && expire > duration {
+		duration = expire
+	}
+	return s.setIdentitySessions(ctx, session.Identity, append(ids, session.ID), duration)
+}
+
+// Get returns the session with the given ID.
+func (s *cacheTokenStore) Get(ctx context.Context, id string) (*Session, error) {
+	v, err := s.cache.Get(ctx, tokenStoreCachePrefix+id)
+	if err != nil || v == nil || v.IsNil() {
+		return nil, err
+	}
+	session := &Session{}
+	if err = json.Unmarshal(v.Bytes(), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Update changes the stored session with the given ID with fn, under the lock of the store.
+func (s *cacheTokenStore) Update(ctx context.Context, id string, fn func(session *Session) time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.Get(ctx, id)
+	if err != nil || session == nil {
+		return false, err
+	}
+	duration := fn(session)
+	if duration <= 0 {
+		return true, nil
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return false, err
+	}
+	return true, s.cache.Set(ctx, tokenStoreCachePrefix+id, string(data), duration)
+}
+
+// Update changes the stored session with the given ID with fn, and writes it only if it has not
+// been changed meanwhile. fn is called again on the session as changed by the concurrent write.
+func (s *redisTokenStore) Update(ctx context.Context, id string, fn func(session *Session) time.Duration) (bool, error) {
+	key := tokenStoreCachePrefix + id
+	for attempt := 0; attempt < sessionUpdateAttempts; attempt++ {
+		v, err := s.redis.Do(ctx, "GET", key)
+		if err != nil || v.IsNil() {
+			return false, err
+		}
+		session := &Session{}
+		if err = json.Unmarshal(v.Bytes(), session); err != nil {
+			return false, err
+		}
+		duration := fn(session)
+		if duration <= 0 {
+			return true, nil
+		}
+		data, err := json.Marshal(session)
+		if err != nil {
+			return false, err
+		}
+		set, err := s.redis.Do(ctx, "EVAL", redisCompareAndSet, 1, key, v.String(), string(data), duration.Milliseconds())
+		if err != nil {
+			return false, err
+		}
+		if !set.IsNil() {
+			return true, nil
+		}
+	}
+	return false, ErrSessionConflict
+}
+
+// Delete removes the session with the given ID.
+func (s *cacheTokenStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// sessions missing from the list of their identity are pruned by List
+	_, err := s.cache.Remove(ctx, tokenStoreCachePrefix+id)
+	return err
+}
+
+// List returns the sessions of identity, oldest first.
+func (s *cacheTokenStore) List(ctx context.Context, identity string) ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.identitySessions(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sessions = make([]*Session, 0, len(ids))
+		live     = make([]string, 0, len(ids))
+	)
+	for _, id := range ids {
+		session, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			sessions = append(sessions, session)
+			live = append(live, id)
+		}
+	}
+
+	// prune sessions that have expired or have been deleted
+	if len(live) < len(ids) {
+		expire, err := s.cache.GetExpire(ctx, tokenStoreIdentityPrefix+identity)
+		if err != nil {
+			return nil, err
+		}
+		if err = s.setIdentitySessions(ctx, identity, live, expire); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].IssuedAt.Before(sessions[j].IssuedAt)
+	})
+	return sessions, nil
+}
+
+// identitySessions returns the IDs of the sessions listed for identity. The caller must hold s.mu.
+func (s *cacheTokenStore) identitySessions(ctx context.Context, identity string) ([]string, error) {
+	v, err := s.cache.Get(ctx, tokenStoreIdentityPrefix+identity)
+	if err != nil || v == nil || v.IsNil() {
+		return nil, err
+	}
+	var ids []string
+	if err = json.Unmarshal(v.Bytes(), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// setIdentitySessions lists the IDs of the sessions of identity for duration. The caller must hold s.mu.
+func (s *cacheTokenStore) setIdentitySessions(ctx context.Context, identity string, ids []string, duration time.Duration) error {
+	if len(ids) == 0 || duration <= 0 {
+		_, err := s.cache.Remove(ctx, tokenStoreIdentityPrefix+identity)
+		return err
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, tokenStoreIdentityPrefix+identity, string(data), duration)
+}
+
+// dbTokenStore is a TokenStore backed by a gdb table. Sessions expire on the clock of the
+// middleware, as their ExpiresAt is set by it.
+type dbTokenStore struct {
+	db       gdb.DB
+	table    string
+	timeFunc func() time.Time
+}
+
+// NewDBTokenStore creates a TokenStore backed by a database table, whose columns are those of
+// the orm tags of Session, with id as primary key and an index on identity. Expired rows of an
+// identity are removed when a session of the identity is stored.
+func NewDBTokenStore(db gdb.DB, table string) TokenStore {
+	return &dbTokenStore{db: db, table: table, timeFunc: time.Now}
+}
+
+// Set stores session, which expires at session.ExpiresAt.
+func (s *dbTokenStore) Set(ctx context.Context, session *Session, duration time.Duration) error {
+	if _, err := s.db.Model(s.table).Ctx(ctx).
+		Where("identity", session.Identity).
+		WhereLT("expires_at", s.timeFunc()).
+		Delete(); err != nil {
+		return err
+	}
+	_, err := s.db.Model(s.table).Ctx(ctx).Data(session).Save()
+	return err
+}
+
+// Get returns the session with the given ID, unless it has expired.
+func (s *dbTokenStore) Get(ctx context.Context, id string) (*Session, error) {
+	record, err := s.db.Model(s.table).Ctx(ctx).
+		Where("id", id).
+		WhereGT("expires_at", s.timeFunc()).
+		One()
+	if err != nil || record.IsEmpty() {
+		return nil, err
+	}
+	session := &Session{}
+	if err = record.Struct(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Update changes the stored session with the given ID with fn, and writes it only if its access
+// token has not been replaced meanwhile: fn is then called again on the session as replaced.
+// Rows deleted meanwhile are not inserted again.
+func (s *dbTokenStore) Update(ctx context.Context, id string, fn func(session *Session) time.Duration) (bool, error) {
+	for attempt := 0; attempt < sessionUpdateAttempts; attempt++ {
+		session, err := s.Get(ctx, id)
+		if err != nil || session == nil {
+			return false, err
+		}
+		tokenID := session.TokenID
+		if fn(session) <= 0 {
+			return true, nil
+		}
+		result, err := s.db.Model(s.table).Ctx(ctx).
+			Data(session).
+			Where("id", id).
+			Where("token_id", tokenID).
+			Update()
+		if err != nil {
+			return false, err
+		}
+		// MySQL does not count rows left unchanged, which have not been replaced either
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err == nil, err
+		}
+		if current, err := s.Get(ctx, id); err != nil || current == nil || current.TokenID == tokenID {
+			return current != nil, err
+		}
+	}
+	return false, ErrSessionConflict
+}
+
+// Delete removes the session with the given ID.
+func (s *dbTokenStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.Model(s.table).Ctx(ctx).Where("id", id).Delete()
+	return err
+}
+
+// List returns the sessions of identity that have not expired, oldest first.
+func (s *dbTokenStore) List(ctx context.Context, identity string) ([]*Session, error) {
+	var sessions []*Session
+	err := s.db.Model(s.table).Ctx(ctx).
+		Where("identity", identity).
+		WhereGT("expires_at", s.timeFunc()).
+		OrderAsc("issued_at").
+		Scan(&sessions)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ListSessions returns the sessions of identity that can still be used, oldest first.
+// Sessions that have timed out on IdleTimeout are removed. Requires TokenStore.
+func (mw *GfJWTMiddleware) ListSessions(ctx context.Context, identity interface{}) ([]*Session, error) {
+	if mw.TokenStore == nil {
+		return nil, ErrMissingTokenStore
+	}
+
+	sessions, err := mw.TokenStore.List(ctx, gconv.String(identity))
+	if err != nil {
+		return nil, mw.revocationFailure(ctx, err)
+	}
+
+	now := mw.TimeFunc()
+	active := sessions[:0]
+	for _, session := range sessions {
+		if !mw.idle(session.LastSeen, now) {
+			active = append(active, session)
+			continue
+		}
+		if err = mw.TokenStore.Delete(ctx, session.ID); err != nil {
+			return nil, mw.revocationFailure(ctx, err)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession signs out the session with the given ID, e.g. on another device: its tokens
+// are rejected from now on. Requires TokenStore.
+func (mw *GfJWTMiddleware) RevokeSession(ctx context.Context, sessionID string) error {
+	if mw.TokenStore == nil {
+		return ErrMissingTokenStore
+	}
+
+	if err := mw.TokenStore.Delete(ctx, sessionID); err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	return nil
+}
+
+// SessionsHandler can be used by clients to list where they are logged in.
+// Shall be put under an endpoint that is using the GfJWTMiddleware.
+// Reply will be of the form [{"id": "SESSION_ID", "device": "DEVICE", ...}].
+func (mw *GfJWTMiddleware) SessionsHandler(ctx context.Context) (sessions []*Session) {
+	sessions, err := mw.ListSessions(ctx, ExtractClaims(ctx)[mw.IdentityKey])
+	if err != nil {
+		mw.unauthorized(ctx, sessionStatus(err), err)
+		return nil
+	}
+	return sessions
+}
+
+// RevokeSessionHandler can be used by clients to sign out one of their sessions, given by the
+// "session_id" parameter of the request. Shall be put under an endpoint that is using the GfJWTMiddleware.
+func (mw *GfJWTMiddleware) RevokeSessionHandler(ctx context.Context) {
+	r := g.RequestFromCtx(ctx)
+	// Get does not fall back to the query once the middleware has set params
+	sessionID := gconv.String(r.GetRequestMap()["session_id"])
+
+	// clients may only sign out their own sessions
+	sessions, err := mw.ListSessions(ctx, ExtractClaims(ctx)[mw.IdentityKey])
+	if err != nil {
+		mw.unauthorized(ctx, sessionStatus(err), err)
+		return
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			if err = mw.RevokeSession(ctx, sessionID); err != nil {
+				mw.unauthorized(ctx, sessionStatus(err), err)
+			}
+			return
+		}
+	}
+
+	mw.unauthorized(ctx, http.StatusNotFound, ErrSessionNotFound)
+}
+
+// startSession adds a new session ID to the claims of a login, if a TokenStore or an IdleTimeout
+// is set. The ID is shared by the tokens refreshed from the login, which thus share their activity.
+func (mw *GfJWTMiddleware) startSession(claims jwt.MapClaims) {
+	if mw.TokenStore != nil || mw.IdleTimeout > 0 {
+		claims[sessionClaim] = guid.S()
+	}
+}
+
+// lockSessions serializes the logins of identity within the process while MaxSessions is
+// enforced, so that concurrent logins do not exceed it together. It returns the unlock function.
+func (mw *GfJWTMiddleware) lockSessions(identity interface{}) func() {
+	key := gconv.String(identity)
+	mw.sessionLocks.Lock(key)
+	return func() { mw.sessionLocks.Unlock(key) }
+}
+
+// checkSessionLimit rejects a new session of identity with ErrTooManySessions if the identity
+// holds MaxSessions sessions already, unless SessionLimitPolicy evicts the oldest ones.
+func (mw *GfJWTMiddleware) checkSessionLimit(ctx context.Context, identity interface{}) error {
+	if mw.MaxSessions <= 0 || mw.SessionLimitPolicy == SessionLimitEvictOldest {
+		return nil
+	}
+
+	sessions, err := mw.ListSessions(ctx, identity)
+	if err != nil {
+		return err
+	}
+	if len(sessions) >= mw.MaxSessions {
+		return ErrTooManySessions
+	}
+	return nil
+}
+
+// evictSessions signs out the oldest sessions of the identity of claims beyond MaxSessions with
+// SessionLimitEvictOldest, once the session of claims has been registered, which is kept.
+func (mw *GfJWTMiddleware) evictSessions(ctx context.Context, claims jwt.MapClaims) error {
+	if mw.MaxSessions <= 0 || mw.SessionLimitPolicy != SessionLimitEvictOldest {
+		return nil
+	}
+
+	sessions, err := mw.ListSessions(ctx, claims[mw.IdentityKey])
+	if err != nil {
+		return err
+	}
+
+	// sessions are listed oldest first
+	sid, _ := claims[sessionClaim].(string)
+	excess := len(sessions) - mw.MaxSessions
+	for _, session := range sessions {
+		if excess <= 0 {
+			break
+		}
+		if session.ID == sid {
+			continue
+		}
+		if err = mw.RevokeSession(ctx, session.ID); err != nil {
+			return err
+		}
+		excess--
+	}
+	return nil
+}
+
+// sessionExpiry returns the time the session of an access token expiring at expire ends: with the
+// last token that may be refreshed.
+func (mw *GfJWTMiddleware) sessionExpiry(expire time.Time) time.Time {
+	if mw.usingRefreshTokens() {
+		return mw.TimeFunc().Add(mw.RefreshTokenTimeout)
+	}
+	return expire.Add(mw.MaxRefresh)
+}
+
+// registerSession stores the session started by a login, whose access token is tokenString.
+func (mw *GfJWTMiddleware) registerSession(ctx context.Context, claims jwt.MapClaims, tokenString string, expire time.Time) error {
+	sid, _ := claims[sessionClaim].(string)
+	if mw.TokenStore == nil || sid == "" {
+		return nil
+	}
+
+	now := mw.TimeFunc()
+	session := &Session{
+		ID:        sid,
+		TokenID:   tokenID(tokenString),
+		Identity:  gconv.String(claims[mw.IdentityKey]),
+		IssuedAt:  now,
+		LastSeen:  now,
+		ExpiresAt: mw.sessionExpiry(expire),
+	}
+	if mw.SessionDeviceFunc != nil {
+		session.Device = mw.SessionDeviceFunc(ctx)
+	}
+	if r := g.RequestFromCtx(ctx); r != nil {
+		session.IP = r.GetClientIp()
+		session.UserAgent = r.UserAgent()
+	}
+
+	if err := mw.TokenStore.Set(ctx, session, session.ExpiresAt.Add(mw.Leeway).Sub(now)); err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	return nil
+}
+
+// renewSession records the access token reissued for the session of claims, which expires at until
+// or later: the expiry of a session is never brought forward. Sessions revoked meanwhile are not
+// stored again, and the reissue fails with ErrRevokedToken.
+func (mw *GfJWTMiddleware) renewSession(ctx context.Context, claims jwt.MapClaims, tokenString string, until time.Time) error {
+	sid, _ := claims[sessionClaim].(string)
+	if mw.TokenStore == nil || sid == "" {
+		return nil
+	}
+
+	now := mw.TimeFunc()
+	found, err := mw.TokenStore.Update(ctx, sid, func(session *Session) time.Duration {
+		session.LastSeen = now
+		if r := g.RequestFromCtx(ctx); r != nil {
+			session.IP = r.GetClientIp()
+			session.UserAgent = r.UserAgent()
+		}
+		session.PreviousTokenID = session.TokenID
+		session.RotatedAt = now
+		session.TokenID = tokenID(tokenString)
+		if until.After(session.ExpiresAt) {
+			session.ExpiresAt = until
+		}
+		return session.ExpiresAt.Add(mw.Leeway).Sub(now)
+	})
+	if err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	if !found {
+		return ErrRevokedToken
+	}
+	return nil
+}
+
+// lookupSession returns the session of a token, or nil without TokenStore. It rejects tokens whose
+// session is not stored, and access tokens other than the last one issued for their session, or
+// the one it replaced within SlidingGracePeriod. Failures of the TokenStore always reject the token.
+func (mw *GfJWTMiddleware) lookupSession(ctx context.Context, claims MapClaims, tokenType string) (*Session, error) {
+	if mw.TokenStore == nil {
+		return nil, nil
+	}
+
+	sid, _ := claims[sessionClaim].(string)
+	if sid == "" {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := mw.TokenStore.Get(ctx, sid)
+	if err != nil {
+		return nil, mw.revocationFailure(ctx, err)
+	}
+	if session == nil {
+		return nil, ErrRevokedToken
+	}
+
+	if tokenType == accessTokenType {
+		jti, _ := claims["jti"].(string)
+		inGracePeriod := jti == session.PreviousTokenID &&
+			mw.TimeFunc().Before(session.RotatedAt.Add(mw.SlidingGracePeriod))
+		if jti != session.TokenID && !inGracePeriod {
+			return nil, ErrRevokedToken
+		}
+	}
+
+	return session, nil
+}
+
+// touchSession records the activity of a request in its session: the time, IP address and user agent.
+// The session is written at most once per SessionActivityInterval, unless the client changes.
+// Failures are only reported, as the request has been authenticated.
+func (mw *GfJWTMiddleware) touchSession(ctx context.Context, session *Session) {
+	r := g.RequestFromCtx(ctx)
+	if session == nil || r == nil {
+		return
+	}
+
+	now := mw.TimeFunc()
+	ip, userAgent := r.GetClientIp(), r.UserAgent()
+	if now.Sub(session.LastSeen) < mw.SessionActivityInterval && ip == session.IP && userAgent == session.UserAgent {
+		return
+	}
+
+	// the session is updated in place, so that a session revoked or refreshed meanwhile is not
+	// stored again or rolled back to the token of the request
+	_, err := mw.TokenStore.Update(ctx, session.ID, func(session *Session) time.Duration {
+		if now.Sub(session.LastSeen) < mw.SessionActivityInterval && ip == session.IP && userAgent == session.UserAgent {
+			return 0
+		}
+		session.LastSeen, session.IP, session.UserAgent = now, ip, userAgent
+		return session.ExpiresAt.Add(mw.Leeway).Sub(now)
+	})
+	if err != nil {
+		_ = mw.revocationFailure(ctx, err)
+	}
+}
+
+// endSession removes the session of claims from the TokenStore.
+func (mw *GfJWTMiddleware) endSession(ctx context.Context, claims jwt.MapClaims) error {
+	sid, _ := claims[sessionClaim].(string)
+	if mw.TokenStore == nil || sid == "" {
+		return nil
+	}
+
+	if err := mw.TokenStore.Delete(ctx, sid); err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	return nil
+}
+
+// sessionStatus returns the HTTP status of a session request failing with err.
+func sessionStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrMissingTokenStore):
+		return http.StatusInternalServerError
+	case errors.Is(err, ErrTooManySessions):
+		return http.StatusForbidden
+	}
+	return revocationStatus(err)
+}
+
+// tokenID returns the jti of a token signed by the middleware.
+func tokenID(tokenString string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	jti, _ := token.Claims.(jwt.MapClaims)["jti"].(string)
+	return jti
+}
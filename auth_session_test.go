@@ -0,0 +1,535 @@
+package jwt
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+func TestSessionActivity(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		TokenStore: NewMemoryTokenStore(),
+		TimeFunc:   clock.Now,
+	})
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		session := func() *Session {
+			sessions, err := mw.ListSessions(ctx, "a")
+			t.AssertNil(err)
+			t.Assert(len(sessions), 1)
+			return sessions[0]
+		}
+		issued := session().LastSeen
+
+		// activity is recorded without IdleTimeout, from the first request of a new client
+		clock.Add(time.Second)
+		client := bearer(c, token).SetAgent("agent")
+		t.Assert(client.GetContent(ctx, "/hello"), "hello")
+		t.Assert(session().IP, "127.0.0.1")
+		t.Assert(session().UserAgent, "agent")
+		t.Assert(session().LastSeen.After(issued), true)
+		seen := session().LastSeen
+
+		// then at most once per SessionActivityInterval
+		clock.Add(30 * time.Second)
+		t.Assert(client.GetContent(ctx, "/hello"), "hello")
+		t.Assert(session().LastSeen.Equal(seen), true)
+
+		clock.Add(time.Minute)
+		t.Assert(client.GetContent(ctx, "/hello"), "hello")
+		t.Assert(session().LastSeen.Equal(clock.Now()), true)
+	})
+}
+
+// newSessionServer serves logins at /login and logouts at /logout, which the handlers
+// authenticate themselves as in the example, and the session endpoints behind the middleware.
+func newSessionServer(t *testing.T, mw *GfJWTMiddleware) *gclient.Client {
+	if mw.Authenticator == nil {
+		mw.Authenticator = func(ctx context.Context) (interface{}, error) {
+			return MapClaims{"identity": g.RequestFromCtx(ctx).Get("username").String()}, nil
+		}
+	}
+	return newTestServer(t, func(s *ghttp.Server) {
+		s.BindHandler("/login", func(r *ghttp.Request) {
+			token, _ := mw.LoginHandler(r.Context())
+			r.Response.WriteJson(g.Map{"token": token})
+		})
+		s.BindHandler("/logout", func(r *ghttp.Request) { mw.LogoutHandler(r.Context()) })
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+			group.ALL("/refresh", func(r *ghttp.Request) {
+				token, _ := mw.RefreshHandler(r.Context())
+				r.Response.WriteJson(g.Map{"token": token})
+			})
+			group.ALL("/sessions", func(r *ghttp.Request) {
+				r.Response.WriteJson(mw.SessionsHandler(r.Context()))
+			})
+			group.ALL("/sessions/revoke", func(r *ghttp.Request) { mw.RevokeSessionHandler(r.Context()) })
+		})
+	})
+}
+
+// login logs username in, and returns the token, or the status of the failure.
+func login(c *gclient.Client, username string) (string, int) {
+	resp, err := c.Post(ctx, "/login", g.Map{"username": username})
+	if err != nil {
+		return "", 0
+	}
+	defer resp.Close()
+	return gjson.New(resp.ReadAll()).Get("token").String(), resp.StatusCode
+}
+
+// statusOf requests path with token, and returns the status of the response.
+func statusOf(c *gclient.Client, token, path string) int {
+	resp, err := bearer(c, token).Get(ctx, path)
+	if err != nil {
+		return 0
+	}
+	defer resp.Close()
+	return resp.StatusCode
+}
+
+func TestSessions(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		TokenStore: NewMemoryTokenStore(),
+		MaxRefresh: time.Hour,
+		TimeFunc:   clock.Now,
+	})
+	c := newSessionServer(t, mw)
+
+	gtest.C(t, func(t *gtest.T) {
+		first, status := login(c, "a")
+		t.Assert(status, http.StatusOK)
+		clock.Add(time.Second)
+		second, _ := login(c, "a")
+		other, _ := login(c, "b")
+		t.Assert(statusOf(c, first, "/hello"), http.StatusOK)
+		t.Assert(statusOf(c, second, "/hello"), http.StatusOK)
+
+		var sessions []*Session
+		t.AssertNil(bearer(c, first).GetVar(ctx, "/sessions").Scan(&sessions))
+		t.Assert(len(sessions), 2)
+		t.Assert(sessions[0].ID, unverifiedClaims(first)[sessionClaim])
+		t.Assert(sessions[1].ID, unverifiedClaims(second)[sessionClaim])
+
+		// tokens without a stored session are rejected
+		stateless := newTestMiddleware(&GfJWTMiddleware{})
+		token, _, err := stateless.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusUnauthorized)
+
+		// only the last access token of a session is accepted
+		refreshed := bearer(c, first).GetVar(ctx, "/refresh").Map()["token"].(string)
+		t.Assert(statusOf(c, first, "/hello"), http.StatusUnauthorized)
+		t.Assert(statusOf(c, refreshed, "/hello"), http.StatusOK)
+
+		// clients sign out their own sessions only
+		t.Assert(statusOf(c, refreshed, "/sessions/revoke?session_id="+unverifiedClaims(other)[sessionClaim].(string)), http.StatusNotFound)
+		t.Assert(statusOf(c, other, "/hello"), http.StatusOK)
+		t.Assert(statusOf(c, refreshed, "/sessions/revoke?session_id="+unverifiedClaims(second)[sessionClaim].(string)), http.StatusOK)
+		t.Assert(statusOf(c, second, "/hello"), http.StatusUnauthorized)
+
+		t.Assert(statusOf(c, refreshed, "/logout"), http.StatusOK)
+		t.Assert(statusOf(c, refreshed, "/hello"), http.StatusUnauthorized)
+		sessions, err = mw.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 0)
+	})
+}
+
+// interleavedTokenStore runs between once, after the first session it returns has been read,
+// as a write of another request would.
+type interleavedTokenStore struct {
+	TokenStore
+	once    sync.Once
+	between func(session *Session)
+}
+
+func (s *interleavedTokenStore) Get(ctx context.Context, id string) (*Session, error) {
+	session, err := s.TokenStore.Get(ctx, id)
+	if session != nil {
+		s.once.Do(func() { s.between(session) })
+	}
+	return session, err
+}
+
+func TestSessionUpdates(t *testing.T) {
+	clock := newTestClock()
+	newServer := func(between func(mw *GfJWTMiddleware, session *Session)) (*GfJWTMiddleware, *gclient.Client) {
+		store := &interleavedTokenStore{TokenStore: NewMemoryTokenStore()}
+		mw := newTestMiddleware(&GfJWTMiddleware{
+			TokenStore: store,
+			MaxRefresh: time.Hour,
+			TimeFunc:   clock.Now,
+		})
+		store.between = func(session *Session) { between(mw, session) }
+		return mw, newSessionServer(t, mw)
+	}
+
+	// a session revoked while a request is authenticated is not stored again by its activity
+	gtest.C(t, func(t *gtest.T) {
+		mw, c := newServer(func(mw *GfJWTMiddleware, session *Session) {
+			t.AssertNil(mw.RevokeSession(ctx, session.ID))
+		})
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		clock.Add(2 * time.Minute)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusOK)
+
+		sessions, err := mw.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 0)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusUnauthorized)
+	})
+
+	// nor is it by a refresh
+	gtest.C(t, func(t *gtest.T) {
+		mw, c := newServer(func(mw *GfJWTMiddleware, session *Session) {
+			t.AssertNil(mw.RevokeSession(ctx, session.ID))
+		})
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(statusOf(c, token, "/refresh"), http.StatusUnauthorized)
+
+		sessions, err := mw.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 0)
+	})
+
+	// the activity of a request does not roll back the token of a refresh meanwhile
+	gtest.C(t, func(t *gtest.T) {
+		var refreshed *Session
+		mw, c := newServer(func(mw *GfJWTMiddleware, session *Session) {
+			refreshed = &Session{}
+			*refreshed = *session
+			refreshed.PreviousTokenID, refreshed.TokenID = session.TokenID, "refreshed"
+			t.AssertNil(mw.TokenStore.Set(ctx, refreshed, time.Hour))
+		})
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		clock.Add(2 * time.Minute)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusOK)
+
+		sessions, err := mw.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 1)
+		t.Assert(sessions[0].TokenID, "refreshed")
+		t.Assert(sessions[0].LastSeen.Equal(clock.Now()), true)
+	})
+
+	// concurrent activity never brings back revoked sessions
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		TokenStore:              NewMemoryTokenStore(),
+		SessionActivityInterval: time.Nanosecond,
+	})
+	c := newSessionServer(t, mw)
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				statusOf(c, token, "/hello")
+			}()
+		}
+		t.AssertNil(mw.RevokeSession(ctx, unverifiedClaims(token)[sessionClaim].(string)))
+		wg.Wait()
+
+		sessions, err := mw.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 0)
+		t.Assert(statusOf(c, token, "/hello"), http.StatusUnauthorized)
+	})
+}
+
+func TestSessionLimits(t *testing.T) {
+	clock := newTestClock()
+	reject := newTestMiddleware(&GfJWTMiddleware{
+		TokenStore:  NewMemoryTokenStore(),
+		MaxSessions: 2,
+		TimeFunc:    clock.Now,
+	})
+	evict := newTestMiddleware(&GfJWTMiddleware{
+		TokenStore:         NewMemoryTokenStore(),
+		MaxSessions:        2,
+		SessionLimitPolicy: SessionLimitEvictOldest,
+		TimeFunc:           clock.Now,
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		c := newSessionServer(t.T, reject)
+		first, _ := login(c, "a")
+		clock.Add(time.Second)
+		_, status := login(c, "a")
+		t.Assert(status, http.StatusOK)
+
+		_, status = login(c, "a")
+		t.Assert(status, http.StatusForbidden)
+		body := c.PostVar(ctx, "/login", g.Map{"username": "a"}).Map()
+		t.Assert(body["message"], ErrTooManySessions.Error())
+
+		// other identities are not limited by the sessions of a
+		_, status = login(c, "b")
+		t.Assert(status, http.StatusOK)
+
+		// signing out makes room for a new session
+		t.Assert(statusOf(c, first, "/logout"), http.StatusOK)
+		_, status = login(c, "a")
+		t.Assert(status, http.StatusOK)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		c := newSessionServer(t.T, evict)
+		first, _ := login(c, "a")
+		clock.Add(time.Second)
+		second, _ := login(c, "a")
+		clock.Add(time.Second)
+		third, status := login(c, "a")
+		t.Assert(status, http.StatusOK)
+
+		// the oldest session is signed out
+		t.Assert(statusOf(c, first, "/hello"), http.StatusUnauthorized)
+		t.Assert(statusOf(c, second, "/hello"), http.StatusOK)
+		t.Assert(statusOf(c, third, "/hello"), http.StatusOK)
+		sessions, err := evict.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 2)
+	})
+}
+
+func TestSessionLimits_Concurrent(t *testing.T) {
+	var failing atomic.Bool
+	newLimited := func(policy SessionLimitPolicy) (*GfJWTMiddleware, *gclient.Client) {
+		mw := newTestMiddleware(&GfJWTMiddleware{
+			TokenStore:         NewMemoryTokenStore(),
+			MaxSessions:        2,
+			SessionLimitPolicy: policy,
+			PayloadFunc: func(data interface{}) MapClaims {
+				claims := data.(MapClaims)
+				if failing.Load() {
+					// claims that can not be encoded fail the token creation
+					claims["unencodable"] = make(chan int)
+				}
+				return claims
+			},
+		})
+		return mw, newSessionServer(t, mw)
+	}
+	loginAll := func(c *gclient.Client, n int) (ok int) {
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			logins = make(chan struct{})
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-logins
+				if _, status := login(c, "a"); status == http.StatusOK {
+					mu.Lock()
+					ok++
+					mu.Unlock()
+				}
+			}()
+		}
+		close(logins)
+		wg.Wait()
+		return ok
+	}
+
+	// concurrent logins do not exceed MaxSessions together
+	reject, rejectClient := newLimited(SessionLimitReject)
+	evict, evictClient := newLimited(SessionLimitEvictOldest)
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(loginAll(rejectClient, 10), 2)
+		sessions, err := reject.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 2)
+
+		t.Assert(loginAll(evictClient, 10), 10)
+		sessions, err = evict.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 2)
+	})
+
+	// sessions are evicted only once the token of the new one has been issued
+	gtest.C(t, func(t *gtest.T) {
+		before, err := evict.ListSessions(ctx, "a")
+		t.AssertNil(err)
+
+		failing.Store(true)
+		_, status := login(evictClient, "a")
+		failing.Store(false)
+		t.Assert(status, http.StatusUnauthorized)
+
+		after, err := evict.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(after, before)
+	})
+}
+
+// recordingSQLDriver is a database/sql driver recording the statements it is given, which
+// affect one row and select none.
+type recordingSQLDriver struct {
+	mu         sync.Mutex
+	statements []recordedStatement
+}
+
+type recordedStatement struct {
+	query string
+	args  []driver.NamedValue
+}
+
+var (
+	recordingSQL         = &recordingSQLDriver{}
+	registerRecordingSQL sync.Once
+)
+
+func (d *recordingSQLDriver) Open(name string) (driver.Conn, error) { return recordingSQLConn{d}, nil }
+
+func (d *recordingSQLDriver) record(query string, args []driver.NamedValue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, recordedStatement{query: query, args: args})
+}
+
+// take returns the statements recorded since it was last called.
+func (d *recordingSQLDriver) take() []recordedStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statements := d.statements
+	d.statements = nil
+	return statements
+}
+
+type recordingSQLConn struct{ d *recordingSQLDriver }
+
+func (c recordingSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c recordingSQLConn) Close() error              { return nil }
+func (c recordingSQLConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c recordingSQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.d.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c recordingSQLConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(query, args)
+	return recordingSQLRows{}, nil
+}
+
+type recordingSQLRows struct{}
+
+func (recordingSQLRows) Columns() []string              { return []string{"id"} }
+func (recordingSQLRows) Close() error                   { return nil }
+func (recordingSQLRows) Next(dest []driver.Value) error { return io.EOF }
+
+// recordingDBDriver is the mysql driver of gdb on top of recordingSQLDriver.
+type recordingDBDriver struct {
+	*gdb.DriverMysql
+}
+
+func (d *recordingDBDriver) New(core *gdb.Core, node *gdb.ConfigNode) (gdb.DB, error) {
+	return &recordingDBDriver{&gdb.DriverMysql{Core: core}}, nil
+}
+
+func (d *recordingDBDriver) Open(config *gdb.ConfigNode) (*sql.DB, error) {
+	return sql.Open("jwt-recording", config.Link)
+}
+
+// TableFields returns the columns of Session, as the table would have.
+func (d *recordingDBDriver) TableFields(ctx context.Context, table string, schema ...string) (map[string]*gdb.TableField, error) {
+	fields := make(map[string]*gdb.TableField)
+	for i, name := range []string{"id", "token_id", "previous_token_id", "rotated_at", "identity", "device", "ip", "user_agent", "issued_at", "last_seen", "expires_at"} {
+		fields[name] = &gdb.TableField{Index: i, Name: name}
+	}
+	return fields, nil
+}
+
+// newRecordingDB returns a gdb.DB whose statements are recorded by recordingSQL.
+func newRecordingDB(t *testing.T) gdb.DB {
+	registerRecordingSQL.Do(func() {
+		sql.Register("jwt-recording", recordingSQL)
+		_ = gdb.Register("jwt-recording", &recordingDBDriver{})
+	})
+	db, err := gdb.New(gdb.ConfigNode{Type: "jwt-recording", Link: "recording"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDBTokenStore(t *testing.T) {
+	clock := newTestClock()
+	clock.Add(-24 * time.Hour)
+	store := NewDBTokenStore(newRecordingDB(t), "sessions")
+	newTestMiddleware(&GfJWTMiddleware{TokenStore: store, TimeFunc: clock.Now})
+
+	gtest.C(t, func(t *gtest.T) {
+		recordingSQL.take()
+		session := &Session{ID: "s", TokenID: "first", Identity: "a", ExpiresAt: clock.Now().Add(time.Hour)}
+		t.AssertNil(store.Set(ctx, session, time.Hour))
+		session.TokenID = "second"
+		t.AssertNil(store.Set(ctx, session, time.Hour))
+
+		// every Set is an upsert of the session, after the removal of the expired sessions of its identity
+		statements := recordingSQL.take()
+		t.Assert(len(statements), 4)
+		for i, tokenID := range []string{"first", "second"} {
+			remove, upsert := statements[2*i], statements[2*i+1]
+			t.Assert(strings.HasPrefix(remove.query, "DELETE FROM `sessions`"), true)
+			t.Assert(strings.Contains(remove.query, "`expires_at` < ?"), true)
+			t.Assert(strings.HasPrefix(upsert.query, "INSERT INTO `sessions`"), true)
+			t.Assert(strings.Contains(upsert.query, "ON DUPLICATE KEY UPDATE"), true)
+			t.Assert(strings.Contains(upsert.query, "`token_id`=VALUES(`token_id`)"), true)
+
+			var values []interface{}
+			for _, arg := range upsert.args {
+				values = append(values, arg.Value)
+			}
+			t.AssertIN(tokenID, values)
+		}
+
+		// sessions expire on the clock of the middleware, not the wall clock
+		_, err := store.Get(ctx, "s")
+		t.AssertNil(err)
+		statements = append(statements[:2], recordingSQL.take()...)
+		for _, statement := range []recordedStatement{statements[0], statements[2]} {
+			expiry := statement.args[len(statement.args)-1].Value
+			t.Assert(gconv.Time(expiry).Unix(), clock.Now().Unix())
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// pathRule is a compiled pattern of IncludePaths or ExcludePaths.
+type pathRule struct {
+	methods map[string]bool
+	path    *regexp.Regexp
+}
+
+// compilePathRules compiles patterns in gf router syntax, such as "/api/public/*",
+// "/user/{id}" or "/user/:id", optionally preceded by methods as in "GET,HEAD:/health".
+func compilePathRules(patterns []string) ([]pathRule, error) {
+	rules := make([]pathRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		rule := pathRule{}
+
+		if i := strings.Index(pattern, ":/"); i > 0 {
+			rule.methods = make(map[string]bool)
+			for _, method := range strings.Split(pattern[:i], ",") {
+				method = strings.ToUpper(strings.TrimSpace(method))
+				if method != "ALL" && method != "*" {
+					rule.methods[method] = true
+				}
+			}
+			pattern = pattern[i+1:]
+		}
+
+		expr, err := regexp.Compile("^" + pathPatternExpr(pattern) + "$")
+		if err != nil {
+			return nil, ErrInvalidPathPattern
+		}
+		rule.path = expr
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// pathPatternExpr converts a path pattern in gf router syntax to a regular expression.
+// Fuzzy segments "*" or "*name" match the rest of the path, if any, and named segments
+// ":name" or "{name}" match within a segment.
+func pathPatternExpr(pattern string) string {
+	var expr strings.Builder
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			expr.WriteString("(/.*)?")
+			return expr.String()
+		case strings.HasPrefix(segment, ":"):
+			expr.WriteString("/[^/]+")
+		default:
+			expr.WriteString("/")
+			for segment != "" {
+				start := strings.Index(segment, "{")
+				end := strings.Index(segment, "}")
+				if start < 0 || end < start {
+					expr.WriteString(regexp.QuoteMeta(segment))
+					break
+				}
+				expr.WriteString(regexp.QuoteMeta(segment[:start]))
+				expr.WriteString("[^/]+")
+				segment = segment[end+1:]
+			}
+		}
+	}
+	if expr.String() == "/" {
+		return "/?"
+	}
+	return expr.String() + "/?"
+}
+
+// match reports whether the rule matches the method and path of a request.
+func (rule pathRule) match(method string, path string) bool {
+	if len(rule.methods) > 0 && !rule.methods[method] {
+		return false
+	}
+	return rule.path.MatchString(path)
+}
+
+// skip reports whether the request passes through without authentication, by Skipper,
+// IncludePaths or ExcludePaths.
+func (mw *GfJWTMiddleware) skip(r *ghttp.Request) bool {
+	if mw.Skipper != nil && mw.Skipper(r) {
+		return true
+	}
+
+	if len(mw.includeRules) > 0 && !matchPathRules(mw.includeRules, r) {
+		return true
+	}
+
+	return matchPathRules(mw.excludeRules, r)
+}
+
+// matchPathRules reports whether any of the rules matches the request.
+func matchPathRules(rules []pathRule, r *ghttp.Request) bool {
+	for _, rule := range rules {
+		if rule.match(r.Method, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package jwt
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+func TestCompilePathRules(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		matches := func(pattern, method, path string) bool {
+			rules, err := compilePathRules([]string{pattern})
+			t.AssertNil(err)
+			return rules[0].match(method, path)
+		}
+
+		// static paths, with or without a trailing slash
+		t.Assert(matches("/health", http.MethodGet, "/health"), true)
+		t.Assert(matches("/health", http.MethodGet, "/health/"), true)
+		t.Assert(matches("/health", http.MethodGet, "/healthz"), false)
+		t.Assert(matches("/health", http.MethodGet, "/health/live"), false)
+		t.Assert(matches("/", http.MethodGet, "/"), true)
+		t.Assert(matches("/", http.MethodGet, "/health"), false)
+
+		// fuzzy segments match the rest of the path, if any
+		t.Assert(matches("/api/public/*", http.MethodGet, "/api/public"), true)
+		t.Assert(matches("/api/public/*", http.MethodGet, "/api/public/a/b"), true)
+		t.Assert(matches("/api/public/*any", http.MethodGet, "/api/public/a"), true)
+		t.Assert(matches("/api/public/*", http.MethodGet, "/api/publicity"), false)
+		t.Assert(matches("/*", http.MethodGet, "/"), true)
+
+		// named segments match within a segment
+		t.Assert(matches("/user/{id}", http.MethodGet, "/user/1"), true)
+		t.Assert(matches("/user/:id", http.MethodGet, "/user/1"), true)
+		t.Assert(matches("/user/{id}", http.MethodGet, "/user/1/posts"), false)
+		t.Assert(matches("/user/{id}", http.MethodGet, "/user/"), false)
+		t.Assert(matches("/file/{name}.json", http.MethodGet, "/file/a.json"), true)
+		t.Assert(matches("/file/{name}.json", http.MethodGet, "/file/a.xml"), false)
+
+		// the rest of the pattern is literal
+		t.Assert(matches("/a.b", http.MethodGet, "/axb"), false)
+
+		// methods filter the requests, ALL and * match any
+		t.Assert(matches("GET,HEAD:/health", http.MethodHead, "/health"), true)
+		t.Assert(matches("get, head:/health", http.MethodGet, "/health"), true)
+		t.Assert(matches("GET,HEAD:/health", http.MethodPost, "/health"), false)
+		t.Assert(matches("OPTIONS:/*", http.MethodOptions, "/api/a"), true)
+		t.Assert(matches("OPTIONS:/*", http.MethodGet, "/api/a"), false)
+		t.Assert(matches("ALL:/health", http.MethodPost, "/health"), true)
+		t.Assert(matches("*:/health", http.MethodPost, "/health"), true)
+	})
+}
+
+func TestSkip(t *testing.T) {
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		Skipper: func(r *ghttp.Request) bool {
+			return r.Header.Get("X-Health-Check") != ""
+		},
+		IncludePaths: []string{"/api/*", "POST:/upload"},
+		ExcludePaths: []string{"/api/public/*", "OPTIONS:/*"},
+	})
+	whoami := func(r *ghttp.Request) {
+		r.Response.Writef("%v|%s", mw.GetIdentity(r.Context()), mw.GetToken(r.Context()))
+	}
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/api/private", whoami)
+			group.ALL("/api/public/feed", whoami)
+			group.ALL("/upload", whoami)
+			group.ALL("/other", whoami)
+		})
+	})
+
+	status := func(method, path string, header map[string]string) int {
+		resp, err := c.Header(header).DoRequest(ctx, method, path)
+		if err != nil {
+			return 0
+		}
+		defer resp.Close()
+		return resp.StatusCode
+	}
+
+	gtest.C(t, func(t *gtest.T) {
+		// requests are authenticated when included and not excluded
+		t.Assert(status(http.MethodGet, "/api/private", nil), http.StatusUnauthorized)
+		t.Assert(status(http.MethodPost, "/upload", nil), http.StatusUnauthorized)
+
+		// exclusions take precedence over inclusions
+		t.Assert(status(http.MethodGet, "/api/public/feed", nil), http.StatusOK)
+		t.Assert(status(http.MethodOptions, "/api/private", nil), http.StatusOK)
+
+		// requests that are not included pass through
+		t.Assert(status(http.MethodGet, "/upload", nil), http.StatusOK)
+		t.Assert(status(http.MethodGet, "/other", nil), http.StatusOK)
+
+		// as do those of the Skipper, whatever the paths
+		t.Assert(status(http.MethodGet, "/api/private", map[string]string{"X-Health-Check": "1"}), http.StatusOK)
+	})
+
+	// skipped requests carry no identity, even with a token or one made up in the request
+	gtest.C(t, func(t *gtest.T) {
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		spoof := "?identity=admin&" + TokenKey + "=token&" + PayloadKey + "=payload"
+
+		t.Assert(bearer(c, token).GetContent(ctx, "/api/private"), "a|"+token)
+		t.Assert(bearer(c, token).GetContent(ctx, "/api/public/feed"+spoof), "|")
+		t.Assert(c.GetContent(ctx, "/other"+spoof), "|")
+		t.Assert(c.Header(map[string]string{"X-Health-Check": "1"}).GetContent(ctx, "/api/private"+spoof), "|")
+	})
+}
@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// slide issues a new access token for a token expiring within SlidingWindow, and revokes the
+// token after SlidingGracePeriod. The reissue is claimed atomically, so that a token is replaced
+// only once. Reissued tokens keep "orig_iat", and expire no later than SlidingMaxLifetime after it.
+func (mw *GfJWTMiddleware) slide(ctx context.Context, token string, claims MapClaims, exp time.Time) error {
+	now := mw.TimeFunc()
+	if mw.SlidingWindow <= 0 || exp.Sub(now) > mw.SlidingWindow {
+		return nil
+	}
+
+	origIat, ok := mw.parseTimestamp(claims["orig_iat"])
+	if !ok {
+		return nil
+	}
+	expire := now.Add(mw.Timeout)
+	if deadline := origIat.Add(mw.SlidingMaxLifetime); expire.After(deadline) {
+		expire = deadline
+	}
+	if !expire.After(exp) {
+		return nil
+	}
+
+	key, err := mw.blacklistKey(token, claims)
+	if err != nil {
+		return err
+	}
+	// the entry revokes the token like setBlacklistAt, and lives at least a second so that it is stored at all
+	duration := mw.revocationDuration(exp)
+	if duration < time.Second {
+		duration = time.Second
+	}
+	first, err := mw.revocationStore.SetIfNotExist(ctx, key, now.Add(mw.SlidingGracePeriod).Unix(), duration)
+	if err != nil {
+		return mw.revocationFailure(ctx, err)
+	}
+	if !first {
+		return nil
+	}
+
+	tokenString, err := mw.signAccessToken(jwt.MapClaims(claims), origIat, expire)
+	if err != nil {
+		return err
+	}
+
+	// the session outlives the reissued token only as far as it did before
+	until := expire
+	if !mw.usingRefreshTokens() {
+		until = expire.Add(mw.MaxRefresh)
+	}
+	if err = mw.renewSession(ctx, jwt.MapClaims(claims), tokenString, until); err != nil {
+		return err
+	}
+
+	r := g.RequestFromCtx(ctx)
+	r.Response.Header().Set(mw.SlidingTokenHeader, mw.TokenHeadName+" "+tokenString)
+
+	// set cookie
+	if mw.SendCookie {
+		r.Cookie.SetCookie(mw.CookieName, tokenString, mw.CookieDomain, "/", mw.CookieMaxAge)
+	}
+
+	return nil
+}
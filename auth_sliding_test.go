@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+)
+
+// slid requests /hello with token, and returns the token reissued in the response, if any.
+func slid(c *gclient.Client, token string) string {
+	resp, err := bearer(c, token).Get(ctx, "/hello")
+	if err != nil {
+		return ""
+	}
+	defer resp.Close()
+	return strings.TrimPrefix(resp.Header.Get("Authorization"), "Bearer ")
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := newTestClock()
+	mw := newTestMiddleware(&GfJWTMiddleware{
+		Timeout:            10 * time.Minute,
+		SlidingWindow:      5 * time.Minute,
+		SlidingMaxLifetime: 15 * time.Minute,
+		TokenStore:         NewMemoryTokenStore(),
+		TimeFunc:           clock.Now,
+	})
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw))
+			group.ALL("/hello", func(r *ghttp.Request) { r.Response.Write("hello") })
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		login := clock.Now()
+		token, _, err := mw.TokenGenerator(MapClaims{"identity": "a"})
+		t.AssertNil(err)
+		t.Assert(slid(c, token), "")
+
+		// concurrent requests in the window reissue the token once
+		clock.Add(6 * time.Minute)
+		var (
+			wg       sync.WaitGroup
+			reissued int32
+			next     atomic.Value
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if s := slid(c, token); s != "" {
+					atomic.AddInt32(&reissued, 1)
+					next.Store(s)
+				}
+			}()
+		}
+		wg.Wait()
+		t.Assert(reissued, 1)
+
+		// the reissued token keeps the login time, and expires no later than SlidingMaxLifetime after it
+		claims := unverifiedClaims(next.Load().(string))
+		origIat, _ := mw.parseTimestamp(claims["orig_iat"])
+		exp, _ := mw.parseTimestamp(claims["exp"])
+		t.Assert(origIat.Unix(), login.Unix())
+		t.Assert(exp.Unix(), login.Add(15*time.Minute).Unix())
+
+		sessions, err := mw.ListSessions(ctx, "a")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 1)
+		t.Assert(sessions[0].ExpiresAt.Unix(), exp.Unix())
+
+		// and is not reissued past the lifetime
+		clock.Add(5 * time.Minute)
+		t.Assert(bearer(c, next.Load().(string)).GetContent(ctx, "/hello"), "hello")
+		t.Assert(slid(c, next.Load().(string)), "")
+	})
+}
@@ -0,0 +1,135 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// RegisteredClaims are the registered claims of RFC 7519. Typed claims embed RegisteredClaims.
+type RegisteredClaims struct {
+	// Issuer of the token, the "iss" claim
+	Issuer string `json:"iss,omitempty"`
+
+	// Subject of the token, the "sub" claim
+	Subject string `json:"sub,omitempty"`
+
+	// Audience of the token, the "aud" claim
+	Audience jwt.ClaimStrings `json:"aud,omitempty"`
+
+	// Expiry of the token, the "exp" claim, in the TimestampUnit of the middleware
+	ExpiresAt int64 `json:"exp,omitempty"`
+
+	// Time before which the token is not valid, the "nbf" claim, in seconds
+	NotBefore int64 `json:"nbf,omitempty"`
+
+	// Time the token was issued, the "iat" claim, in seconds
+	IssuedAt int64 `json:"iat,omitempty"`
+
+	// Unique ID of the token, the "jti" claim
+	ID string `json:"jti,omitempty"`
+}
+
+// Registered returns the registered claims.
+func (c RegisteredClaims) Registered() RegisteredClaims {
+	return c
+}
+
+// Claims is the constraint of typed claims, which is satisfied by any struct embedding RegisteredClaims.
+type Claims interface {
+	Registered() RegisteredClaims
+}
+
+// ClaimsValidator is implemented by typed claims that check their own claims.
+// Validate is called after the registered claims have been checked by the middleware.
+type ClaimsValidator interface {
+	Validate() error
+}
+
+// TypedMiddleware is a GfJWTMiddleware whose claims are decoded into T.
+type TypedMiddleware[T Claims] struct {
+	*GfJWTMiddleware
+}
+
+// NewTyped for check error with GfJWTMiddleware, decoding claims into T. The payloadFunc callback
+// is called during login and its claims are added to the web token, in place of PayloadFunc; no token
+// is issued if they can not be encoded. Tokens whose claims can not be decoded into T, or whose T
+// fails ClaimsValidator, are rejected by the middleware and on refresh.
+func NewTyped[T Claims](mw *GfJWTMiddleware, payloadFunc func(data interface{}) T) *TypedMiddleware[T] {
+	if payloadFunc != nil {
+		mw.payloadEncoder = func(data interface{}) (MapClaims, error) {
+			return encodeClaims(payloadFunc(data))
+		}
+	}
+
+	mw.claimsValidator = func(claims MapClaims) error {
+		_, err := decodeClaims[T](claims)
+		return err
+	}
+
+	return &TypedMiddleware[T]{GfJWTMiddleware: New(mw)}
+}
+
+// GetClaims help to extract the JWT claims of the request decoded into T
+func (mw *TypedMiddleware[T]) GetClaims(ctx context.Context) (T, error) {
+	return ExtractClaimsAs[T](ctx)
+}
+
+// ExtractClaimsAs help to extract the JWT claims decoded into T
+func ExtractClaimsAs[T Claims](ctx context.Context) (T, error) {
+	r := g.RequestFromCtx(ctx)
+	claims, ok := r.GetParam(PayloadKey).Interface().(MapClaims)
+	if !ok {
+		var empty T
+		return empty, ErrMissingClaims
+	}
+	return decodeClaims[T](claims)
+}
+
+// encodeClaims converts typed claims to MapClaims.
+func encodeClaims(v interface{}) (MapClaims, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	claims := MapClaims{}
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// decodeClaims converts MapClaims to typed claims, and validates them if they implement ClaimsValidator.
+func decodeClaims[T Claims](claims MapClaims) (T, error) {
+	var typed T
+
+	// NumericDate claims may have a fraction, which does not decode into an integer
+	normalized := make(MapClaims, len(claims))
+	for key, value := range claims {
+		switch key {
+		case "exp", "nbf", "iat", "orig_iat":
+			if n, ok := numericDate(value); ok {
+				value = n
+			}
+		}
+		normalized[key] = value
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return typed, ErrInvalidClaims
+	}
+	if err = json.Unmarshal(data, &typed); err != nil {
+		return typed, ErrInvalidClaims
+	}
+
+	if validator, ok := interface{}(&typed).(ClaimsValidator); ok {
+		if err = validator.Validate(); err != nil {
+			return typed, err
+		}
+	}
+
+	return typed, nil
+}
@@ -0,0 +1,133 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+var errBannedRole = errors.New("banned role")
+
+// testClaims are typed claims that refuse the banned role.
+type testClaims struct {
+	RegisteredClaims
+	Identity string      `json:"identity"`
+	Role     string      `json:"role"`
+	Extra    interface{} `json:"extra,omitempty"`
+}
+
+func (c testClaims) Validate() error {
+	if c.Role == "banned" {
+		return errBannedRole
+	}
+	return nil
+}
+
+// newTypedMiddleware creates a TypedMiddleware of testClaims, which logs in the testClaims of the request.
+func newTypedMiddleware(mw *GfJWTMiddleware) *TypedMiddleware[testClaims] {
+	mw.Key = []byte("secret key")
+	mw.Authenticator = func(ctx context.Context) (interface{}, error) {
+		r := g.RequestFromCtx(ctx)
+		claims := testClaims{Identity: r.Get("username").String(), Role: r.Get("role").String()}
+		if r.Get("unencodable").Bool() {
+			claims.Extra = func() {}
+		}
+		return claims, nil
+	}
+	return NewTyped(mw, func(data interface{}) testClaims {
+		return data.(testClaims)
+	})
+}
+
+func TestTypedMiddleware(t *testing.T) {
+	mw := newTypedMiddleware(&GfJWTMiddleware{Issuer: "issuer"})
+	c := newTestServer(t, func(s *ghttp.Server) {
+		s.BindHandler("/login", func(r *ghttp.Request) {
+			token, _ := mw.LoginHandler(r.Context())
+			r.Response.WriteJson(g.Map{"token": token})
+		})
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.Middleware(authMiddleware(mw.GfJWTMiddleware))
+			group.ALL("/claims", func(r *ghttp.Request) {
+				claims, err := mw.GetClaims(r.Context())
+				if err != nil {
+					r.Response.WriteStatus(http.StatusInternalServerError)
+					return
+				}
+				r.Response.WriteJson(claims)
+			})
+		})
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		token := c.PostVar(ctx, "/login", g.Map{"username": "a", "role": "admin"}).Map()["token"]
+		t.AssertNE(token, "")
+
+		var claims testClaims
+		t.AssertNil(bearer(c, gconv.String(token)).GetVar(ctx, "/claims").Scan(&claims))
+		t.Assert(claims.Identity, "a")
+		t.Assert(claims.Role, "admin")
+		t.Assert(claims.Issuer, "issuer")
+		t.AssertNE(claims.ID, "")
+		t.AssertGT(claims.ExpiresAt, 0)
+
+		// the typed claims of the token are validated
+		token = c.PostVar(ctx, "/login", g.Map{"username": "a", "role": "banned"}).Map()["token"]
+		t.AssertNE(token, "")
+		t.Assert(statusOf(c, gconv.String(token), "/claims"), http.StatusUnauthorized)
+	})
+
+	// claims that can not be encoded issue no token
+	gtest.C(t, func(t *gtest.T) {
+		body := c.PostVar(ctx, "/login", g.Map{"username": "a", "unencodable": true}).Map()
+		t.Assert(body["code"], http.StatusUnauthorized)
+		t.Assert(body["message"], ErrFailedTokenCreation.Error())
+
+		_, _, err := mw.TokenGenerator(testClaims{Identity: "a", Extra: func() {}})
+		t.AssertNE(err, nil)
+	})
+}
+
+func TestTypedMiddleware_Refresh(t *testing.T) {
+	// tokens refreshed within MaxRefresh
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTypedMiddleware(&GfJWTMiddleware{MaxRefresh: time.Hour})
+		c := newTestServer(t.T, func(s *ghttp.Server) {
+			s.BindHandler("/refresh", func(r *ghttp.Request) {
+				token, _ := mw.RefreshHandler(r.Context())
+				r.Response.WriteJson(g.Map{"token": token})
+			})
+			s.BindHandler("/check", func(r *ghttp.Request) {
+				_, _, err := mw.CheckIfTokenExpire(r.Context())
+				r.Response.Write(err)
+			})
+		})
+
+		for role, err := range map[string]error{"admin": nil, "banned": errBannedRole} {
+			token, _, e := mw.TokenGenerator(testClaims{Identity: "a", Role: role})
+			t.AssertNil(e)
+			t.Assert(bearer(c, token).GetContent(ctx, "/check"), gconv.String(err))
+			refreshed := gconv.String(bearer(c, token).GetVar(ctx, "/refresh").Map()["token"])
+			t.Assert(refreshed != "", err == nil)
+		}
+	})
+
+	// token pairs refreshed with a refresh token
+	gtest.C(t, func(t *gtest.T) {
+		mw := newTypedMiddleware(&GfJWTMiddleware{RefreshTokenTimeout: time.Hour})
+		c := newRefreshServer(t.T, mw.GfJWTMiddleware)
+
+		for role, err := range map[string]error{"admin": nil, "banned": errBannedRole} {
+			login, e := mw.TokenPairGenerator(testClaims{Identity: "a", Role: role})
+			t.AssertNil(e)
+			t.Assert(refresh(c, login.RefreshToken) != nil, err == nil)
+		}
+	})
+}